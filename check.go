@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jhammer/phishtankcheck/phishtank"
+)
+
+// check loads db once, along with any OpenPhish feed, and writes those of urls
+// found in it to stdout, one per line. With no urls it checks the URLs read
+// from stdin instead. It returns the exit status: 1 if any URL was found, 0 if
+// none were and 2 on error.
+func check(db *phishtank.DB, urls []string, stdin io.Reader, stdout io.Writer) int {
+	err := db.Load(context.Background())
+
+	if err != nil && !db.Ready() {
+		fmt.Fprintln(os.Stderr, "Error loading database:", err)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading database, using cached copy:", err)
+	}
+
+	err = db.LoadOpenPhish(context.Background())
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading OpenPhish feed:", err)
+	}
+
+	if len(urls) == 0 {
+		urls, err = readLines(stdin)
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading URLs:", err)
+			return 2
+		}
+	}
+
+	found := db.Search(urls)
+
+	for _, url := range found {
+		fmt.Fprintln(stdout, url)
+	}
+
+	if len(found) > 0 {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envFlags maps environment variables to the flags they provide values for.
+var envFlags = map[string]string{
+	"PHISHTANK_USERNAME": "username",
+	"PHISHTANK_API_KEY":  "apiKey",
+	"PHISHTANK_PORT":     "port",
+	"PHISHTANK_REFRESH":  "refresh",
+}
+
+// setFlags returns the names of the flags that have already been set.
+func setFlags() map[string]bool {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	return set
+}
+
+// loadEnv sets each flag in envFlags not given on the command line from its
+// environment variable.
+func loadEnv() error {
+	set := setFlags()
+
+	for env, name := range envFlags {
+		value, present := os.LookupEnv(env)
+
+		if !present || set[name] {
+			continue
+		}
+
+		err := flag.Set(name, value)
+
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %v", env, err)
+		}
+	}
+
+	return nil
+}
+
+// loadConfig sets each flag not given on the command line from the config file
+// at path. The file is a YAML (or JSON) object keyed by flag name, so every
+// command-line option can also be set from it.
+func loadConfig(path string) error {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	var values map[string]interface{}
+
+	err = yaml.Unmarshal(data, &values)
+
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %v", path, err)
+	}
+
+	set := setFlags()
+
+	for name, value := range values {
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("unknown option %q in %s", name, path)
+		}
+
+		if set[name] {
+			continue
+		}
+
+		err = flag.Set(name, fmt.Sprint(value))
+
+		if err != nil {
+			return fmt.Errorf("invalid value for %q in %s: %v", name, path, err)
+		}
+	}
+
+	return nil
+}
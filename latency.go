@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the buckets a latencyHistogram
+// counts durations in. Longer durations fall in a final, unbounded bucket.
+var latencyBuckets = [...]time.Duration{
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// latencyHistogram counts durations in fixed buckets. The zero value is an
+// empty histogram, safe for concurrent use.
+type latencyHistogram struct {
+	counts [len(latencyBuckets) + 1]int64
+	sum    int64
+	max    int64
+}
+
+// observe adds d to the histogram.
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := 0
+
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+
+	for {
+		current := atomic.LoadInt64(&h.max)
+
+		if int64(d) <= current || atomic.CompareAndSwapInt64(&h.max, current, int64(d)) {
+			break
+		}
+	}
+}
+
+// time calls next, adding how long it took to the histogram.
+func (h *latencyHistogram) time(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next(w, r)
+		h.observe(time.Since(start))
+	}
+}
+
+// latencySummary summarizes a latencyHistogram. The percentiles are the upper
+// bounds of the buckets they fall in, so overestimate by up to a bucket.
+type latencySummary struct {
+	Count int64
+	P50   string
+	P95   string
+	Max   string
+}
+
+func (h *latencyHistogram) summary() latencySummary {
+	var counts [len(latencyBuckets) + 1]int64
+	var count int64
+
+	for i := range counts {
+		counts[i] = atomic.LoadInt64(&h.counts[i])
+		count += counts[i]
+	}
+
+	longest := time.Duration(atomic.LoadInt64(&h.max))
+
+	// percentile returns the bound of the bucket in which the fraction p of
+	// durations is reached, or the maximum if that's lower.
+	percentile := func(p float64) time.Duration {
+		var seen int64
+
+		for i, n := range counts {
+			seen += n
+
+			if float64(seen) >= p*float64(count) && i < len(latencyBuckets) {
+				return min(latencyBuckets[i], longest)
+			}
+		}
+
+		return longest
+	}
+
+	return latencySummary{
+		Count: count,
+		P50:   percentile(0.5).String(),
+		P95:   percentile(0.95).String(),
+		Max:   longest.String(),
+	}
+}
+
+// writeHistogram writes h as a Prometheus histogram, in seconds.
+func writeHistogram(w io.Writer, name string, help string, h *latencyHistogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+
+	var count int64
+
+	for i := range h.counts {
+		count += atomic.LoadInt64(&h.counts[i])
+		le := "+Inf"
+
+		if i < len(latencyBuckets) {
+			le = strconv.FormatFloat(latencyBuckets[i].Seconds(), 'g', -1, 64)
+		}
+
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, le, count)
+	}
+
+	sum := time.Duration(atomic.LoadInt64(&h.sum))
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(sum.Seconds(), 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, count)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"log/syslog"
+	"os"
+	"strings"
+)
+
+// newLogger returns the logger for the given -logFormat and -log destination.
+// By default the text format logs to syslog, falling back to stderr if syslog
+// is unavailable, and the json format logs to stderr. A logger writing to
+// stderr also takes over the standard log package's output.
+func newLogger(format string, dest string, sys syslogConfig) (*slog.Logger, error) {
+	if format != "text" && format != "json" {
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+
+	var fallback error
+
+	switch dest {
+	case "":
+		if format == "json" {
+			break
+		}
+
+		logger, err := newSyslogLogger(format, sys)
+
+		if err == nil {
+			return logger, nil
+		}
+
+		fallback = err
+	case "syslog":
+		return newSyslogLogger(format, sys)
+	case "stderr":
+	default:
+		return nil, fmt.Errorf("unknown log destination %q", dest)
+	}
+
+	var logger *slog.Logger
+
+	if format == "json" {
+		logger = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	} else {
+		logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+	}
+
+	slog.SetDefault(logger)
+
+	if fallback != nil {
+		logger.Warn("Syslog unavailable, logging to stderr", "error", fallback)
+	}
+
+	return logger, nil
+}
+
+// syslogConfig is where and how logs are sent to syslog. An empty network
+// and address mean the local syslog daemon.
+type syslogConfig struct {
+	network  string
+	addr     string
+	tag      string
+	facility syslog.Priority
+}
+
+// syslogFacilities maps -syslogFacility names to syslog facilities.
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":   syslog.LOG_KERN,
+	"user":   syslog.LOG_USER,
+	"mail":   syslog.LOG_MAIL,
+	"daemon": syslog.LOG_DAEMON,
+	"auth":   syslog.LOG_AUTH,
+	"syslog": syslog.LOG_SYSLOG,
+	"local0": syslog.LOG_LOCAL0,
+	"local1": syslog.LOG_LOCAL1,
+	"local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3,
+	"local4": syslog.LOG_LOCAL4,
+	"local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6,
+	"local7": syslog.LOG_LOCAL7,
+}
+
+// parseFacility returns the syslog facility with the given name.
+func parseFacility(name string) (syslog.Priority, error) {
+	facility, ok := syslogFacilities[strings.ToLower(name)]
+
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility %q", name)
+	}
+
+	return facility, nil
+}
+
+// newSyslogLogger returns a logger writing to syslog as configured by sys, in
+// the given format.
+func newSyslogLogger(format string, sys syslogConfig) (*slog.Logger, error) {
+	writer, err := syslog.Dial(sys.network, sys.addr, syslog.LOG_INFO|sys.facility, sys.tag)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(writer, nil)), nil
+	}
+
+	return slog.New(&syslogHandler{writer: writer}), nil
+}
+
+// syslogHandler writes log records to syslog as the message followed by
+// key=value attributes, at the syslog severity matching the record's level.
+type syslogHandler struct {
+	writer *syslog.Writer
+	attrs  []slog.Attr
+}
+
+func (h *syslogHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *syslogHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+
+	b.WriteString(r.Message)
+
+	write := func(a slog.Attr) bool {
+		fmt.Fprintf(&b, " %s=%s", a.Key, a.Value.Resolve())
+		return true
+	}
+
+	for _, a := range h.attrs {
+		write(a)
+	}
+
+	r.Attrs(write)
+
+	switch {
+	case r.Level >= slog.LevelError:
+		return h.writer.Err(b.String())
+	case r.Level >= slog.LevelWarn:
+		return h.writer.Warning(b.String())
+	case r.Level >= slog.LevelInfo:
+		return h.writer.Info(b.String())
+	default:
+		return h.writer.Debug(b.String())
+	}
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &syslogHandler{
+		writer: h.writer,
+		attrs:  append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...),
+	}
+}
+
+// WithGroup returns h unchanged, as groups aren't used and attributes are
+// written flat.
+func (h *syslogHandler) WithGroup(string) slog.Handler {
+	return h
+}
@@ -1,231 +1,530 @@
 package main
 
 import (
-	"compress/bzip2"
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
 	"log"
-	"log/syslog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
-	"sync"
-	"sync/atomic"
+	"syscall"
 	"time"
+
+	"github.com/jhammer/phishtankcheck/phishtank"
 )
 
-type phish struct {
-	URL string `json:"url"`
+// removeStaleSocket removes the Unix domain socket at path left behind by a
+// previous run. It refuses to remove anything that isn't a socket.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	return os.Remove(path)
 }
 
-type database struct {
-	username       string
-	apiKey         string
-	lastUpdated    time.Time
-	eTag           string
-	urls           map[string]struct{}
-	mutex          sync.RWMutex
-	searchCount    int64
-	searchURLCount int64
-	hitURLCount    int64
+// writePidFile writes the process ID to path, replacing any file there.
+func writePidFile(path string) error {
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
 }
 
-func (d *database) newRequest(method string) (*http.Request, error) {
-	req, err := http.NewRequest(method, fmt.Sprintf("http://data.phishtank.com/data/%s/online-valid.json.bz2", d.apiKey), nil)
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
 
-	if err != nil {
-		return nil, err
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
 
-	req.Header.Set("User-Agent", "phishtank/"+d.username)
-	return req, nil
+	return items
 }
 
-func (d *database) load() error {
-	if d.eTag != "" {
-		req, err := d.newRequest(http.MethodHead)
+func main() {
+	startTime := time.Now()
 
-		if err != nil {
-			return err
-		}
+	versionPtr := flag.Bool("version", false, "print the version and exit")
+	checkPtr := flag.Bool("check", false, "check the URLs given as arguments, or on stdin, and exit 1 if any are phishing")
+	configPtr := flag.String("config", "", "YAML or JSON config file of option values, overridden by flags")
+	portPtr := flag.String("port", "", "port to listen on ($PHISHTANK_PORT)")
+	bindPtr := flag.String("bind", "", "address to listen on (default all interfaces)")
+	socketPtr := flag.String("socket", "", "Unix domain socket to listen on instead of a TCP port")
+	pidFilePtr := flag.String("pidFile", "", "file to write the process ID to, removed on shutdown")
+	refreshHoursPtr := flag.Int("refresh", 1, "refresh interval in hours ($PHISHTANK_REFRESH)")
+	usernamePtr := flag.String("username", "", "Phishtank username ($PHISHTANK_USERNAME)")
+	apiKeyPtr := flag.String("apiKey", "", "Phishtank API key, or comma-separated keys tried in turn ($PHISHTANK_API_KEY)")
+	dataURLPtr := flag.String("dataURL", phishtank.DefaultDataURL, "URL of the Phishtank feed, with {apiKey} replaced by the API key and {feed} by -feed")
+	feedPtr := flag.String("feed", phishtank.DefaultFeed, "Phishtank feed variant: "+strings.Join(phishtank.Feeds, " or "))
+	verifiedOnlyPtr := flag.Bool("verifiedOnly", false, "load only feed entries verified as phishing")
+	onlineOnlyPtr := flag.Bool("onlineOnly", false, "load only feed entries marked as online")
+	headCheckPtr := flag.Bool("headCheck", false, "check the feed's ETag with a HEAD request, for mirrors that don't support conditional GETs")
+	userAgentPtr := flag.String("userAgent", "", "User-Agent for Phishtank requests (default \"phishtank/<username>\")")
+	ignoreSchemePtr := flag.Bool("ignoreScheme", false, "treat http and https URLs as equivalent (may increase false positives)")
+	ignoreQueryPtr := flag.Bool("ignoreQuery", false, "ignore the query string when matching (may increase false positives)")
+	ignoreFragmentPtr := flag.Bool("ignoreFragment", false, "ignore the fragment when matching")
+	ignoreWWWPtr := flag.Bool("ignoreWWW", false, "ignore a leading \"www.\" on hosts when matching (may increase false positives)")
+	matchSubdomainsPtr := flag.Bool("matchSubdomains", false, "make domain searches match subdomains of hosts in the database (may increase false positives)")
+	matchPathPrefixPtr := flag.Bool("matchPathPrefix", false, "match URLs below a listed URL's path on the same host (may increase false positives)")
+	indexPtr := flag.Bool("index", false, "index URLs by host and target for /by-domain and /by-target (uses more memory)")
+	bloomPtr := flag.Bool("bloom", false, "hold URLs in a Bloom filter to save memory, at the cost of feed details and occasional false positives")
+	bloomFalsePositiveRatePtr := flag.Float64("bloomFalsePositiveRate", 0.0001, "false-positive rate of the Bloom filter")
+	tlsCertPtr := flag.String("tlsCert", "", "TLS certificate file (enables HTTPS)")
+	tlsKeyPtr := flag.String("tlsKey", "", "TLS private key file (enables HTTPS)")
+	cacheControlPtr := flag.Bool("cacheControl", true, "schedule refreshes by the feed's Cache-Control max-age or Expires when it gives one, instead of -refresh")
+	refreshJitterPtr := flag.Float64("refreshJitter", 0, "random fraction of the refresh interval added to each refresh")
+	filePtr := flag.String("file", "", "load the database from this local feed file instead of Phishtank")
+	watchPtr := flag.Bool("watch", false, "reload the -file feed whenever it changes")
+	watchDelayPtr := flag.Duration("watchDelay", time.Second, "how long the -file feed must go unchanged before -watch reloads it")
+	openPhishURLPtr := flag.String("openphishURL", "", "URL of an OpenPhish feed whose URLs are matched as well as Phishtank's")
+	openPhishRefreshPtr := flag.Duration("openphishRefresh", time.Hour, "refresh interval of the OpenPhish feed")
+	extraListPtr := flag.String("extraList", "", "file of extra phishing URLs, one per line, merged into the database on every refresh")
+	allowlistPtr := flag.String("allowlist", "", "file of URLs, one per line, never reported as phishing; re-read on every refresh")
+	cacheDirPtr := flag.String("cacheDir", "", "directory in which to cache the database between restarts")
+	maxStalenessPtr := flag.Duration("maxStaleness", 24*time.Hour, "time without a successful refresh after which the database is reported as stale (0 to disable)")
+	startupRetriesPtr := flag.Int("startupRetries", 0, "number of times to retry a failed initial load before giving up")
+	startupRetryDelayPtr := flag.Duration("startupRetryDelay", 10*time.Second, "delay between retries of the initial load")
+	startupStrictPtr := flag.Bool("startupStrict", false, "exit if the initial load still fails after -startupRetries, rather than starting not ready")
+	retryDelayPtr := flag.Duration("retryDelay", time.Minute, "initial delay before retrying a failed refresh")
+	maxRetryDelayPtr := flag.Duration("maxRetryDelay", 30*time.Minute, "maximum delay between retries of a failed refresh")
+	proxyPtr := flag.String("proxy", "", "HTTP or SOCKS5 proxy URL for fetching feeds, such as socks5://localhost:1080 (default from the environment)")
+	caFilePtr := flag.String("caFile", "", "PEM file of CA certificates to trust, besides the system's, when fetching feeds")
+	insecureSkipVerifyPtr := flag.Bool("insecureSkipVerify", false, "don't verify the feed server's TLS certificate (DANGEROUS: allows the feed to be tampered with)")
+	fetchTimeoutPtr := flag.Duration("fetchTimeout", phishtank.DefaultFetchTimeout, "timeout for fetching the Phishtank database")
+	authTokenPtr := flag.String("authToken", "", "bearer token required to use the service")
+	maxBodyBytesPtr := flag.Int64("maxBodyBytes", 1<<20, "maximum size of a search request body in bytes")
+	maxURLsPtr := flag.Int("maxURLs", 10000, "maximum number of URLs in a search request (0 for unlimited)")
+	validateURLsPtr := flag.Bool("validateURLs", false, "reject searches listing anything but valid absolute URLs (also ?validate=true)")
+	rateLimitPtr := flag.Float64("rateLimit", 0, "searches per second allowed from each client IP (0 for unlimited)")
+	rateBurstPtr := flag.Int("rateBurst", 10, "searches a client may make in a burst above -rateLimit")
+	trustProxyPtr := flag.Bool("trustProxy", false, "take the client IP from X-Forwarded-For")
+	corsOriginPtr := flag.String("corsOrigin", "", "origin allowed to make cross-origin requests (\"*\" for any)")
+	minEntriesPtr := flag.Int("minEntries", 0, "refuse to load a feed with fewer entries than this, keeping the current data")
+	maxShrinkPtr := flag.Float64("maxShrink", 50, "refuse to load a feed with more than this percentage fewer entries than the current one (0 to disable)")
+	drainPtr := flag.Duration("drain", 0, "turn searches away with 503 for this long after each reload, so that runs of searches see one dataset (0 to disable)")
+	maxDiffPtr := flag.Int("maxDiff", 1000, "maximum number of added and removed URLs kept for /diff")
+	webhookURLPtr := flag.String("webhookURL", "", "URL to POST a notification to when the database changes")
+	webhookTimeoutPtr := flag.Duration("webhookTimeout", 10*time.Second, "timeout for webhook notifications")
+	accessLogPtr := flag.Bool("accessLog", false, "log every request with its status, size and duration")
+	logFormatPtr := flag.String("logFormat", "text", "log format: text or json")
+	syslogNetworkPtr := flag.String("syslogNetwork", "", "network of a remote syslog server: tcp or udp (default the local syslog daemon)")
+	syslogAddrPtr := flag.String("syslogAddr", "", "address of a remote syslog server, as host:port")
+	syslogTagPtr := flag.String("syslogTag", "", "tag of syslog messages (default the program name)")
+	syslogFacilityPtr := flag.String("syslogFacility", "daemon", "syslog facility, such as daemon or local0")
+	logPtr := flag.String("log", "", "log destination: syslog or stderr (default syslog for text, falling back to stderr, and stderr for json)")
+	readTimeoutPtr := flag.Duration("readTimeout", 10*time.Second, "maximum time to read a request, including its body (0 for none)")
+	writeTimeoutPtr := flag.Duration("writeTimeout", 30*time.Second, "maximum time to write a response (0 for none)")
+	idleTimeoutPtr := flag.Duration("idleTimeout", 120*time.Second, "how long an idle keep-alive connection is kept open (0 for none)")
+	maxHeaderBytesPtr := flag.Int("maxHeaderBytes", http.DefaultMaxHeaderBytes, "maximum size of request headers in bytes")
+	otelEndpointPtr := flag.String("otelEndpoint", "", "URL of an OTLP/HTTP collector, such as http://localhost:4318, to send traces of requests and loads to (disabled by default)")
+	pprofAddrPtr := flag.String("pprofAddr", "", "address such as localhost:6060 to serve net/http/pprof on (disabled by default)")
+	shutdownTimeoutPtr := flag.Duration("shutdownTimeout", 10*time.Second, "grace period for in-flight requests on shutdown")
 
-		res, err := http.DefaultClient.Do(req)
+	flag.Parse()
+
+	if *versionPtr {
+		b := currentBuild()
+		fmt.Printf("phishtankcheck %s (commit %s, built %s)\n", b.Version, b.Commit, b.Date)
+		return
+	}
+
+	err := loadEnv()
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if *configPtr != "" {
+		err = loadConfig(*configPtr)
 
 		if err != nil {
-			return err
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
+	}
+
+	if *portPtr == "" && *socketPtr == "" && !*checkPtr {
+		fmt.Fprintln(os.Stderr, "Port number or socket required")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *filePtr == "" && (*usernamePtr == "" || *apiKeyPtr == "") {
+		fmt.Fprintln(os.Stderr, "Phishtank username and API key required")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
 
-		defer res.Body.Close()
+	addr := net.JoinHostPort(*bindPtr, *portPtr)
 
-		if res.Header.Get("ETag") == d.eTag {
-			return nil
+	if *socketPtr == "" {
+		if _, err := net.ResolveTCPAddr("tcp", addr); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid listen address %s: %v\n", addr, err)
+			os.Exit(1)
 		}
 	}
 
-	req, err := d.newRequest(http.MethodGet)
+	if !phishtank.ValidFeed(*feedPtr) {
+		fmt.Fprintf(os.Stderr, "Unknown feed %q: must be %s\n", *feedPtr, strings.Join(phishtank.Feeds, " or "))
+		os.Exit(1)
+	}
 
-	if err != nil {
-		return err
+	if *refreshHoursPtr <= 0 {
+		fmt.Fprintln(os.Stderr, "-refresh must be at least 1 hour")
+		os.Exit(1)
 	}
 
-	res, err := http.DefaultClient.Do(req)
+	if *retryDelayPtr <= 0 {
+		fmt.Fprintln(os.Stderr, "-retryDelay must be positive")
+		os.Exit(1)
+	}
+
+	if *maxRetryDelayPtr < *retryDelayPtr {
+		fmt.Fprintln(os.Stderr, "-maxRetryDelay must not be less than -retryDelay")
+		os.Exit(1)
+	}
+
+	if *startupRetriesPtr < 0 {
+		fmt.Fprintln(os.Stderr, "-startupRetries must not be negative")
+		os.Exit(1)
+	}
+
+	if *bloomFalsePositiveRatePtr <= 0 || *bloomFalsePositiveRatePtr >= 1 {
+		fmt.Fprintln(os.Stderr, "-bloomFalsePositiveRate must be between 0 and 1")
+		os.Exit(1)
+	}
+
+	if *openPhishRefreshPtr <= 0 {
+		fmt.Fprintln(os.Stderr, "-openphishRefresh must be positive")
+		os.Exit(1)
+	}
+
+	if *watchPtr && *filePtr == "" {
+		fmt.Fprintln(os.Stderr, "-watch requires -file")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if (*tlsCertPtr == "") != (*tlsKeyPtr == "") {
+		fmt.Fprintln(os.Stderr, "TLS certificate and key must be given together")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	facility, err := parseFacility(*syslogFacilityPtr)
 
 	if err != nil {
-		return err
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if (*syslogNetworkPtr == "") != (*syslogAddrPtr == "") {
+		fmt.Fprintln(os.Stderr, "Syslog network and address must be given together")
+		flag.PrintDefaults()
+		os.Exit(1)
 	}
 
-	defer res.Body.Close()
+	logger, err := newLogger(*logFormatPtr, *logPtr, syslogConfig{
+		network:  *syslogNetworkPtr,
+		addr:     *syslogAddrPtr,
+		tag:      *syslogTagPtr,
+		facility: facility,
+	})
 
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status fetching %s: %v", req.URL, res.StatusCode)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	var phishes []phish
+	shutdownTracing := func(context.Context) error { return nil }
+
+	if *otelEndpointPtr != "" {
+		shutdownTracing, err = setupTracing(*otelEndpointPtr)
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
 
-	err = json.NewDecoder(bzip2.NewReader(res.Body)).Decode(&phishes)
+	transport, err := newTransport(transportConfig{
+		proxy:              *proxyPtr,
+		caFile:             *caFilePtr,
+		insecureSkipVerify: *insecureSkipVerifyPtr,
+	})
 
 	if err != nil {
-		return err
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
-	urls := make(map[string]struct{}, 0)
+	if *insecureSkipVerifyPtr {
+		logger.Warn("TLS certificate verification of the feed is disabled")
+	}
 
-	for _, phish := range phishes {
-		urls[strings.ToLower(phish.URL)] = struct{}{}
+	opts := []phishtank.Option{
+		phishtank.WithCredentials(*usernamePtr, splitList(*apiKeyPtr)...),
+		phishtank.WithLogger(logger),
+		phishtank.WithHTTPClient(&http.Client{Transport: transport, Timeout: *fetchTimeoutPtr}),
+		phishtank.WithIgnoreScheme(*ignoreSchemePtr),
+		phishtank.WithIgnoreQuery(*ignoreQueryPtr),
+		phishtank.WithIgnoreFragment(*ignoreFragmentPtr),
+		phishtank.WithIgnoreWWW(*ignoreWWWPtr),
+		phishtank.WithFile(*filePtr),
+		phishtank.WithExtraList(*extraListPtr),
+		phishtank.WithAllowlist(*allowlistPtr),
+		phishtank.WithOpenPhish(*openPhishURLPtr),
+		phishtank.WithUserAgent(*userAgentPtr),
+		phishtank.WithDataURL(*dataURLPtr),
+		phishtank.WithFeed(*feedPtr),
+		phishtank.WithVerifiedOnly(*verifiedOnlyPtr),
+		phishtank.WithOnlineOnly(*onlineOnlyPtr),
+		phishtank.WithHEADCheck(*headCheckPtr),
+		phishtank.WithIndex(*indexPtr),
+		phishtank.WithDrain(*drainPtr),
+		phishtank.WithMaxDiff(*maxDiffPtr),
+		phishtank.WithMatchSubdomains(*matchSubdomainsPtr),
+		phishtank.WithPathPrefix(*matchPathPrefixPtr),
+		phishtank.WithMinEntries(*minEntriesPtr),
+		phishtank.WithMaxShrink(*maxShrinkPtr),
+		phishtank.WithCacheDir(*cacheDirPtr),
 	}
 
-	d.eTag = res.Header.Get("ETag")
-	d.mutex.Lock()
-	d.lastUpdated = time.Now()
-	d.urls = urls
-	d.mutex.Unlock()
+	if *bloomPtr {
+		opts = append(opts, phishtank.WithBloomFilter(*bloomFalsePositiveRatePtr))
+	}
 
-	return nil
-}
+	if *webhookURLPtr != "" {
+		hook := &webhook{
+			url:    *webhookURLPtr,
+			client: &http.Client{Timeout: *webhookTimeoutPtr},
+			logger: logger,
+		}
+		opts = append(opts, phishtank.WithOnChange(hook.notify))
+	}
 
-func (d *database) search(urls []string) []string {
-	atomic.AddInt64(&d.searchCount, 1)
-	atomic.AddInt64(&d.searchURLCount, int64(len(urls)))
+	db := phishtank.New(opts...)
 
-	d.mutex.RLock()
-	defer d.mutex.RUnlock()
+	if *cacheDirPtr != "" {
+		err = os.MkdirAll(*cacheDirPtr, 0755)
 
-	found := make([]string, 0)
+		if err != nil {
+			log.Fatal(err)
+		}
 
-	for _, url := range urls {
-		_, present := d.urls[strings.ToLower(url)]
+		err = db.LoadCache()
 
-		if present {
-			found = append(found, url)
+		if err != nil {
+			logger.Error("Error loading cached database", "error", err)
 		}
 	}
 
-	atomic.AddInt64(&d.hitURLCount, int64(len(found)))
+	if *checkPtr {
+		os.Exit(check(db, flag.Args(), os.Stdin, os.Stdout))
+	}
 
-	return found
-}
+	// ctx lasts until shutdown begins, when cancelling it aborts the startup
+	// load or any refresh in progress.
+	ctx, stop := context.WithCancel(context.Background())
+	done := ctx.Done()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		logger.Info("Shutting down", "signal", sig.String())
+		stop()
+	}()
+
+	err = initialLoad(ctx, db, logger, *startupRetriesPtr, *startupRetryDelayPtr)
 
-func newDatabase(username string, apiKey string) *database {
-	return &database{
-		username: username,
-		apiKey:   apiKey,
+	if ctx.Err() != nil {
+		shutdownTracing(context.Background())
+		return
 	}
-}
 
-func main() {
-	startTime := time.Now()
+	if err != nil {
+		logger.Error("Error loading database", "error", err)
 
-	portPtr := flag.String("port", "", "port to listen on")
-	refreshHoursPtr := flag.Int("refresh", 1, "refresh interval in hours")
-	usernamePtr := flag.String("username", "", "Phishtank username")
-	apiKeyPtr := flag.String("apiKey", "", "Phishtank API key")
+		if *startupStrictPtr {
+			os.Exit(1)
+		}
+	} else {
+		c := db.LastChange()
+		logger.Info("Loaded database", "entries", c.EntryCount, "suppressed", c.Suppressed)
+	}
 
-	flag.Parse()
+	refreshLoop := &refresher{
+		ctx:           ctx,
+		db:            db,
+		logger:        logger,
+		interval:      time.Duration(*refreshHoursPtr) * time.Hour,
+		useHint:       *cacheControlPtr,
+		jitter:        *refreshJitterPtr,
+		retryDelay:    *retryDelayPtr,
+		maxRetryDelay: *maxRetryDelayPtr,
+	}
+	go refreshLoop.run(err == nil && db.Ready())
 
-	if *portPtr == "" {
-		fmt.Fprintln(os.Stderr, "Port number required")
-		flag.PrintDefaults()
-		os.Exit(1)
+	if *openPhishURLPtr != "" {
+		err = db.LoadOpenPhish(ctx)
+
+		if err != nil {
+			logger.Error("Error loading OpenPhish feed", "error", err)
+		}
+
+		go refreshOpenPhish(ctx, db, logger, *openPhishRefreshPtr)
 	}
 
-	if *usernamePtr == "" || *apiKeyPtr == "" {
-		fmt.Fprintln(os.Stderr, "Phishtank username and API key required")
-		flag.PrintDefaults()
-		os.Exit(1)
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-hangups:
+				refreshLoop.reload("SIGHUP")
+			}
+		}
+	}()
+
+	if *watchPtr {
+		err = watchFile(*filePtr, *watchDelayPtr, func() { refreshLoop.reload("file change") }, logger, done)
+
+		if err != nil {
+			logger.Error("Error watching feed file", "file", *filePtr, "error", err)
+		}
+	}
+
+	s := &server{
+		db:           db,
+		refresher:    refreshLoop,
+		startTime:    startTime,
+		maxStaleness: *maxStalenessPtr,
+		maxBodyBytes: *maxBodyBytesPtr,
+		maxURLs:      *maxURLsPtr,
+		authToken:    *authTokenPtr,
+		validateURLs: *validateURLsPtr,
+		indexed:      *indexPtr,
+		tracing:      *otelEndpointPtr != "",
 	}
 
-	logger, err := syslog.Dial("", "", syslog.LOG_INFO|syslog.LOG_DAEMON, "")
+	if *rateLimitPtr > 0 {
+		s.limiter = newRateLimiter(*rateLimitPtr, *rateBurstPtr, *trustProxyPtr)
+		go s.limiter.run(10*time.Minute, done)
+	}
 
-	if err != nil {
-		log.Fatal(err)
+	mux := http.NewServeMux()
+	s.routes(mux)
+
+	if *pprofAddrPtr != "" {
+		go servePprof(*pprofAddrPtr)
 	}
 
-	db := newDatabase(*usernamePtr, *apiKeyPtr)
-	err = db.load()
+	var listener net.Listener
+
+	if *socketPtr != "" {
+		err = removeStaleSocket(*socketPtr)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		listener, err = net.Listen("unix", *socketPtr)
+	} else {
+		listener, err = net.Listen("tcp", addr)
+	}
 
 	if err != nil {
 		log.Fatal(err)
-		os.Exit(1)
 	}
 
-	ticker := time.NewTicker(time.Duration(*refreshHoursPtr) * time.Hour)
+	// The PID file is only written once the server is listening, and is
+	// removed however it stops from here on, so that none is left behind by
+	// a run that never started serving.
+	removePidFile := func() {
+		if *pidFilePtr != "" {
+			os.Remove(*pidFilePtr)
+		}
+	}
+
+	if *pidFilePtr != "" {
+		err = writePidFile(*pidFilePtr)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing PID file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var handler http.Handler = mux
+
+	if *corsOriginPtr != "" {
+		handler = cors(*corsOriginPtr, handler)
+	}
+
+	if *accessLogPtr {
+		handler = accessLog(logger, *trustProxyPtr, handler)
+	}
+
+	handler = withRequestID(handler)
+
+	srv := &http.Server{
+		Handler:        handler,
+		ReadTimeout:    *readTimeoutPtr,
+		WriteTimeout:   *writeTimeoutPtr,
+		IdleTimeout:    *idleTimeoutPtr,
+		MaxHeaderBytes: *maxHeaderBytesPtr,
+	}
+
 	go func() {
-		for {
-			<-ticker.C
+		log.Print("Listening on " + listener.Addr().String())
 
-			err := db.load()
+		var err error
 
-			if err != nil {
-				logger.Err(fmt.Sprintf("Error refreshing database: %v", err))
-			} else {
-				logger.Info("Refreshed database")
-			}
+		if *tlsCertPtr != "" {
+			err = srv.ServeTLS(listener, *tlsCertPtr, *tlsKeyPtr)
+		} else {
+			err = srv.Serve(listener)
 		}
-	}()
 
-	http.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "", http.StatusMethodNotAllowed)
-			return
+		if err != http.ErrServerClosed {
+			removePidFile()
+			log.Fatal(err)
 		}
+	}()
 
-		var urls []string
+	<-done
 
-		err := json.NewDecoder(r.Body).Decode(&urls)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeoutPtr)
+	defer cancel()
 
-		if err != nil {
-			http.Error(w, "Error decoding body", http.StatusBadRequest)
-			return
-		}
+	err = srv.Shutdown(shutdownCtx)
+	shutdownTracing(shutdownCtx)
 
-		phish := db.search(urls)
+	if *socketPtr != "" {
+		removeStaleSocket(*socketPtr)
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(phish)
-	})
-	http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
-		db.mutex.RLock()
-		defer db.mutex.RUnlock()
-		status := struct {
-			Uptime         string
-			LastUpdated    time.Time
-			EntryCount     int
-			SearchCount    int64
-			SearchURLCount int64
-			HitURLCount    int64
-		}{
-			Uptime:         time.Since(startTime).String(),
-			LastUpdated:    db.lastUpdated,
-			EntryCount:     len(db.urls),
-			SearchCount:    atomic.LoadInt64(&db.searchCount),
-			SearchURLCount: atomic.LoadInt64(&db.searchURLCount),
-			HitURLCount:    atomic.LoadInt64(&db.hitURLCount),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(status)
-	})
+	removePidFile()
 
-	log.Print("Listening on " + *portPtr)
-	log.Fatal(http.ListenAndServe(":"+*portPtr, nil))
+	if err != nil {
+		logger.Error("Error shutting down", "error", err)
+		os.Exit(1)
+	}
 }
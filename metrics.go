@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/jhammer/phishtankcheck/phishtank"
+)
+
+// writeMetric writes a single sample in the Prometheus text exposition format.
+func writeMetric(w io.Writer, name string, kind string, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+	fmt.Fprintf(w, "%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// metricsHandler serves the database counters and search latency for scraping
+// by Prometheus.
+func metricsHandler(db *phishtank.DB, latency *latencyHistogram) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats := db.Stats()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		writeMetric(w, "phishtank_search_requests_total", "counter", "Total number of search requests.",
+			float64(stats.SearchCount))
+		writeMetric(w, "phishtank_searched_urls_total", "counter", "Total number of URLs searched.",
+			float64(stats.SearchURLCount))
+		writeMetric(w, "phishtank_matched_urls_total", "counter", "Total number of searched URLs found in the database.",
+			float64(stats.HitURLCount))
+		writeMetric(w, "phishtank_database_entries", "gauge", "Number of URLs in the database.",
+			float64(stats.EntryCount))
+
+		if !stats.LastUpdated.IsZero() {
+			writeMetric(w, "phishtank_database_age_seconds", "gauge", "Seconds since the database was last refreshed.",
+				db.Age().Seconds())
+		}
+
+		writeHistogram(w, "phishtank_search_duration_seconds", "Time taken to answer search requests.", latency)
+	}
+}
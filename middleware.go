@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jhammer/phishtankcheck/phishtank"
+)
+
+// cors allows browsers to call next from origin ("*" for any), answering CORS
+// preflight requests itself.
+func cors(origin string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+
+		if origin != "*" {
+			w.Header().Add("Vary", "Origin")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID")
+			w.Header().Set("Access-Control-Max-Age", "86400")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestIDKey is the context key under which withRequestID stores the ID of
+// a request.
+type requestIDKey struct{}
+
+// maxRequestIDLength is the longest incoming X-Request-ID taken as is.
+const maxRequestIDLength = 128
+
+// withRequestID gives each request to next an ID, echoed in the X-Request-ID
+// response header and logged with it, so that a client's report of a request
+// can be matched with the server's logs. An X-Request-ID sent by the client,
+// or a proxy in front, is used if it's reasonable; otherwise a random one is
+// made up.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// validRequestID reports whether id is a non-empty, printable ASCII ID no
+// longer than maxRequestIDLength, and so safe to log and echo back.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestIDFrom returns the ID withRequestID gave r, or "" if none.
+func requestIDFrom(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// statusRecorder is a ResponseWriter that records the status and size of the
+// response written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// accessLog logs each request to next once it has been answered, with its
+// status, response size and duration.
+func accessLog(logger *slog.Logger, trustProxy bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		logger.Info("Request",
+			"id", requestIDFrom(r),
+			"method", r.Method,
+			"path", r.URL.Path,
+			"client", clientIP(r, trustProxy),
+			"status", recorder.status,
+			"bytes", recorder.bytes,
+			"duration", time.Since(start).String(),
+		)
+	})
+}
+
+// gzipMinSize is the size below which responses aren't worth compressing.
+const gzipMinSize = 1024
+
+// gzipWriter is a ResponseWriter that gzips the response once it reaches
+// gzipMinSize, and otherwise writes it as is. Nothing is written until either
+// that much has been written or the response is closed.
+type gzipWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+	gz     *gzip.Writer
+}
+
+func (w *gzipWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+
+	w.buf.Write(b)
+
+	if w.buf.Len() < gzipMinSize {
+		return len(b), nil
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+	w.writeHeader()
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+
+	_, err := w.gz.Write(w.buf.Bytes())
+	w.buf.Reset()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (w *gzipWriter) writeHeader() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// close finishes the response, writing it uncompressed if it stayed small.
+func (w *gzipWriter) close() error {
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+
+	w.writeHeader()
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	return err
+}
+
+// acceptsGzip reports whether the client making r accepts gzipped responses.
+func acceptsGzip(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(accept), ";")
+
+		if strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+
+	return false
+}
+
+// compress gzips responses from next larger than gzipMinSize for clients that
+// accept it.
+func compress(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if !acceptsGzip(r) {
+			next(w, r)
+			return
+		}
+
+		gw := &gzipWriter{ResponseWriter: w}
+		defer gw.close()
+		next(gw, r)
+	}
+}
+
+// requireReady rejects requests to next with 503 until db has first loaded,
+// rather than answering that every URL is clean, and while it's draining
+// after a reload.
+func requireReady(db *phishtank.DB, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !db.Ready() {
+			http.Error(w, "Database not yet loaded", http.StatusServiceUnavailable)
+			return
+		}
+
+		if db.Draining() {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Database is being reloaded", http.StatusServiceUnavailable)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// requireToken rejects requests to next that don't present token as a bearer
+// token. An empty token lets all requests through.
+func requireToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	if token == "" {
+		return next
+	}
+
+	expected := []byte("Bearer " + token)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// clientIP returns the IP address of the client making r. If trustProxy is set,
+// the address appended to X-Forwarded-For by the proxy in front of us is used,
+// as earlier entries can be forged by the client.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			hops := strings.Split(forwarded, ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,74 @@
+package phishtank
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// bloomFilter is a Bloom filter of strings. It never misses a string that was
+// added, but reports strings that weren't added as present at a rate set when
+// it is created, in exchange for using a small fraction of the memory of a map.
+type bloomFilter struct {
+	bits   []uint64
+	size   uint64
+	hashes uint64
+}
+
+// newBloomFilter returns a filter sized to hold n strings with the given
+// false-positive rate.
+func newBloomFilter(n int, falsePositiveRate float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+
+	size := uint64(math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+
+	if size < 1 {
+		size = 1
+	}
+
+	hashes := uint64(math.Round(float64(size) / float64(n) * math.Ln2))
+
+	if hashes < 1 {
+		hashes = 1
+	}
+
+	return &bloomFilter{
+		bits:   make([]uint64, (size+63)/64),
+		size:   size,
+		hashes: hashes,
+	}
+}
+
+// positions calls fn with each bit position for s, derived from a single hash
+// by double hashing.
+func (f *bloomFilter) positions(s string, fn func(uint64) bool) {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	sum := h.Sum64()
+	h1, h2 := sum&math.MaxUint32, sum>>32|1
+
+	for i := uint64(0); i < f.hashes; i++ {
+		if !fn((h1 + i*h2) % f.size) {
+			return
+		}
+	}
+}
+
+func (f *bloomFilter) add(s string) {
+	f.positions(s, func(bit uint64) bool {
+		f.bits[bit/64] |= 1 << (bit % 64)
+		return true
+	})
+}
+
+func (f *bloomFilter) has(s string) bool {
+	present := true
+
+	f.positions(s, func(bit uint64) bool {
+		present = f.bits[bit/64]&(1<<(bit%64)) != 0
+		return present
+	})
+
+	return present
+}
@@ -0,0 +1,108 @@
+package phishtank
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	cacheFeedFile         = "feed.json.bz2"
+	cacheETagFile         = "etag"
+	cacheLastModifiedFile = "last-modified"
+	cacheSourceFile       = "source"
+)
+
+// cacheSource identifies the feed the DB loads, so that a cache saved from
+// another, such as a different feed variant, isn't loaded in its place. The
+// API key is left out, so it isn't written to disk and can be changed.
+func (d *DB) cacheSource() string {
+	return strings.ReplaceAll(d.dataURL, "{feed}", d.feed)
+}
+
+// saveCache moves the feed being teed into cache into place in the cache
+// directory, along with its validators and source. The rest of body is drained
+// first so that the whole feed is saved.
+func (d *DB) saveCache(body io.Reader, cache *os.File, v validators) error {
+	_, err := io.Copy(io.Discard, body)
+
+	if err != nil {
+		return err
+	}
+
+	err = cache.Close()
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(cache.Name(), filepath.Join(d.cacheDir, cacheFeedFile))
+
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(filepath.Join(d.cacheDir, cacheETagFile), []byte(v.eTag), 0644)
+
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(filepath.Join(d.cacheDir, cacheLastModifiedFile), []byte(v.lastModified), 0644)
+
+	if err != nil {
+		return err
+	}
+
+	// The source is written last, so that a cache left half written isn't
+	// taken for one of the feed.
+	return os.WriteFile(filepath.Join(d.cacheDir, cacheSourceFile), []byte(d.cacheSource()), 0644)
+}
+
+// LoadCache loads the database from the feed saved in the cache directory by a
+// previous run, if there is one and it was fetched from the same source. Its
+// ETag and Last-Modified time are restored so the next load only downloads a
+// changed feed.
+func (d *DB) LoadCache() error {
+	path := filepath.Join(d.cacheDir, cacheFeedFile)
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	source, err := os.ReadFile(filepath.Join(d.cacheDir, cacheSourceFile))
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if string(source) != d.cacheSource() {
+		d.logger.Info("Ignoring cached feed from a different source", "source", string(source))
+		return nil
+	}
+
+	eTag, err := os.ReadFile(filepath.Join(d.cacheDir, cacheETagFile))
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	lastModified, err := os.ReadFile(filepath.Join(d.cacheDir, cacheLastModifiedFile))
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	_, err = d.readFile(path, validators{eTag: string(eTag), lastModified: string(lastModified)})
+
+	if err != nil {
+		return err
+	}
+
+	if d.extraList != "" || d.allowlist != "" {
+		return d.loadLists()
+	}
+
+	return nil
+}
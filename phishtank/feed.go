@@ -0,0 +1,35 @@
+package phishtank
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+)
+
+// decompress detects whether the feed in r is bzip2-compressed,
+// gzip-compressed or plain JSON, returning a reader of the JSON.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(3)
+
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return bzip2.NewReader(br), nil
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return decompress(gz)
+	}
+
+	return br, nil
+}
@@ -0,0 +1,167 @@
+package phishtank
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// urlList is a set of URLs from a local list file, such as the extra list or
+// the allowlist. It is kept apart from the feed so that it can be re-read on
+// every load, whether the feed has changed or not.
+type urlList struct {
+	urls  map[string]Phish
+	hosts map[string]struct{}
+}
+
+// readList reads the list file at path.
+func (d *DB) readList(path string) (*urlList, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return d.parseList(f)
+}
+
+// parseList parses a list of URLs from r: one URL per line, ignoring blank
+// lines and lines starting with "#".
+func (d *DB) parseList(r io.Reader) (*urlList, error) {
+	list := &urlList{
+		urls:  make(map[string]Phish),
+		hosts: make(map[string]struct{}),
+	}
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		url := strings.TrimSpace(scanner.Text())
+
+		if url == "" || strings.HasPrefix(url, "#") {
+			continue
+		}
+
+		list.urls[d.normalizer.normalize(url)] = Phish{URL: url}
+
+		if host := d.normalizer.hostname(url); host != "" {
+			list.hosts[host] = struct{}{}
+		}
+	}
+
+	return list, scanner.Err()
+}
+
+// loadLists re-reads the extra list and allowlist into the current snapshot,
+// so that edits to them take effect on the next load.
+func (d *DB) loadLists() error {
+	var extra, allowed *urlList
+	var err error
+
+	if d.extraList != "" {
+		extra, err = d.readList(d.extraList)
+
+		if err != nil {
+			return fmt.Errorf("error reading extra list: %v", err)
+		}
+	}
+
+	if d.allowlist != "" {
+		allowed, err = d.readList(d.allowlist)
+
+		if err != nil {
+			return fmt.Errorf("error reading allowlist: %v", err)
+		}
+	}
+
+	current := d.current.Load()
+
+	if current == nil {
+		return nil
+	}
+
+	snap := *current
+	snap.extra = extra
+	snap.allowed = allowed
+	snap.listCount = 0
+	snap.suppressed = 0
+
+	// listCount is the number of entries the lists add to the feed: the extra
+	// URLs that aren't already in it, less the feed URLs allowlisted.
+	if allowed != nil {
+		for key := range allowed.urls {
+			if _, present := current.getFeed(key, ""); present {
+				snap.suppressed++
+				snap.listCount--
+			} else if _, present := extra.get(key); present {
+				snap.suppressed++
+			}
+		}
+	}
+
+	if extra != nil {
+		for key := range extra.urls {
+			_, inFeed := current.getFeed(key, "")
+			_, isAllowed := allowed.get(key)
+
+			if !inFeed && !isAllowed {
+				snap.listCount++
+			}
+		}
+	}
+
+	// The first reading of the lists completes the first load rather than
+	// changing data already served, so it doesn't count as a change.
+	first := extra != nil && current.extra == nil || allowed != nil && current.allowed == nil
+	changed := !first && (!extra.equal(current.extra) || !allowed.equal(current.allowed))
+
+	d.mutex.Lock()
+	snap.openPhish = d.openPhish
+	d.storeLocked(&snap, changed, d.now())
+	d.mutex.Unlock()
+
+	return nil
+}
+
+// get returns the entry for the URL with the given normalized key, if it's in
+// the list. A nil list is empty.
+func (l *urlList) get(key string) (Phish, bool) {
+	if l == nil {
+		return Phish{}, false
+	}
+
+	p, present := l.urls[key]
+	return p, present
+}
+
+// equal reports whether l and other list the same URLs. A nil list equals
+// only another nil list.
+func (l *urlList) equal(other *urlList) bool {
+	if l == nil || other == nil {
+		return l == other
+	}
+
+	if len(l.urls) != len(other.urls) {
+		return false
+	}
+
+	for key, p := range l.urls {
+		if q, present := other.urls[key]; !present || q != p {
+			return false
+		}
+	}
+
+	return true
+}
+
+// all returns the URLs in l by key, or nil if l is nil.
+func (l *urlList) all() map[string]Phish {
+	if l == nil {
+		return nil
+	}
+
+	return l.urls
+}
@@ -0,0 +1,329 @@
+package phishtank_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jhammer/phishtankcheck/phishtank"
+	"github.com/jhammer/phishtankcheck/phishtank/phishtanktest"
+)
+
+// newTestDB returns a DB loading from s, configured further by opts.
+func newTestDB(s *phishtanktest.Server, opts ...phishtank.Option) *phishtank.DB {
+	opts = append(append(s.Options(), phishtank.WithCredentials("test", "key")), opts...)
+	return phishtank.New(opts...)
+}
+
+// writeList writes a list file of urls to dir, returning its path.
+func writeList(t *testing.T, dir string, name string, urls ...string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	var data []byte
+
+	for _, url := range urls {
+		data = append(data, url+"\n"...)
+	}
+
+	err := os.WriteFile(path, data, 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func load(t *testing.T, db *phishtank.DB) {
+	t.Helper()
+
+	err := db.Load(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDrainOnlyOnChange(t *testing.T) {
+	s := phishtanktest.NewServer("http://evil.example/login")
+	defer s.Close()
+
+	dir := t.TempDir()
+	allowlist := writeList(t, dir, "allow.txt", "http://good.example/")
+	db := newTestDB(s, phishtank.WithDrain(time.Hour), phishtank.WithAllowlist(allowlist))
+
+	load(t, db)
+
+	if db.Draining() {
+		t.Fatal("draining after the first load")
+	}
+
+	// The feed is answered 304 and the allowlist re-read unchanged.
+	load(t, db)
+
+	if db.Draining() {
+		t.Fatal("draining after a load that changed nothing")
+	}
+
+	writeList(t, dir, "allow.txt", "http://good.example/", "http://fine.example/")
+	load(t, db)
+
+	if !db.Draining() {
+		t.Fatal("not draining after the allowlist changed")
+	}
+
+	db = newTestDB(s, phishtank.WithDrain(time.Hour))
+	load(t, db)
+	s.SetURLs("http://evil.example/login", "http://evil.example/kit")
+	load(t, db)
+
+	if !db.Draining() {
+		t.Fatal("not draining after the feed changed")
+	}
+}
+
+func TestVersionOnlyChangesWithData(t *testing.T) {
+	s := phishtanktest.NewServer("http://evil.example/login")
+	defer s.Close()
+
+	dir := t.TempDir()
+	extra := writeList(t, dir, "extra.txt", "http://extra.example/")
+	db := newTestDB(s, phishtank.WithExtraList(extra))
+
+	load(t, db)
+	version := db.Version()
+
+	// The feed is answered 304 and the extra list re-read unchanged.
+	load(t, db)
+
+	if db.Version() != version {
+		t.Fatalf("Version changed from %s to %s on an unchanged reload", version, db.Version())
+	}
+
+	// Nor does a feed fetched again, under a new ETag, with the same records.
+	s.SetFeed([]byte(`[{"phish_id": 1, "url": "http://evil.example/login", "verified": "yes", "online": "yes"}]`))
+	updated := db.Updated()
+	load(t, db)
+
+	if !db.Updated().After(updated) {
+		t.Fatal("feed not fetched again")
+	}
+
+	if db.Version() != version {
+		t.Fatalf("Version changed from %s to %s on a reload of the same feed", version, db.Version())
+	}
+
+	writeList(t, dir, "extra.txt", "http://extra.example/", "http://more.example/")
+	load(t, db)
+
+	if db.Version() == version {
+		t.Fatal("Version unchanged after the extra list changed")
+	}
+
+	version = db.Version()
+	s.SetURLs("http://evil.example/login", "http://evil.example/kit")
+	load(t, db)
+
+	if db.Version() == version {
+		t.Fatal("Version unchanged after the feed changed")
+	}
+}
+
+func TestOnChangeFileOnlyOnChange(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "feed.json")
+	feed := `[{"phish_id": 1, "url": "http://evil.example/login"}]`
+
+	err := os.WriteFile(path, []byte(feed), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var changes []phishtank.Change
+
+	db := phishtank.New(phishtank.WithFile(path), phishtank.WithOnChange(func(c phishtank.Change) {
+		changes = append(changes, c)
+	}))
+
+	load(t, db)
+	load(t, db)
+
+	if len(changes) != 1 {
+		t.Fatalf("%d changes after reloading an unchanged file, want 1", len(changes))
+	}
+
+	feed = `[{"phish_id": 1, "url": "http://evil.example/login"}, {"phish_id": 2, "url": "http://evil.example/kit"}]`
+
+	err = os.WriteFile(path, []byte(feed), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	load(t, db)
+
+	if len(changes) != 2 || changes[1].Added != 1 {
+		t.Fatalf("changes = %+v, want a second adding 1", changes)
+	}
+}
+
+func TestAgeSinceLastRefresh(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	// An unchanged feed file is as fresh as the last reload of it.
+	path := filepath.Join(t.TempDir(), "feed.json")
+
+	err := os.WriteFile(path, []byte(`[{"phish_id": 1, "url": "http://evil.example/login"}]`), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Chtimes(path, now.Add(-72*time.Hour), now.Add(-72*time.Hour))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := phishtank.New(phishtank.WithFile(path), phishtank.WithClock(clock))
+	load(t, db)
+	now = now.Add(time.Hour)
+	load(t, db)
+
+	if age := db.Age(); age != 0 {
+		t.Errorf("file Age = %v after reloading it, want 0", age)
+	}
+
+	// So is a feed answered 304, and a failed refresh leaves the age growing.
+	s := phishtanktest.NewServer("http://evil.example/login")
+	defer s.Close()
+
+	db = newTestDB(s, phishtank.WithClock(clock))
+	load(t, db)
+	now = now.Add(time.Hour)
+	load(t, db)
+
+	if age := db.Age(); age != 0 {
+		t.Errorf("Age = %v after a 304, want 0", age)
+	}
+
+	s.Fail(500)
+	now = now.Add(time.Hour)
+
+	if db.Load(context.Background()) == nil {
+		t.Fatal("Load succeeded against a failing server")
+	}
+
+	if age := db.Age(); age != time.Hour {
+		t.Errorf("Age = %v after a failed refresh, want 1h", age)
+	}
+}
+
+func TestLoadCacheOfAnotherFeed(t *testing.T) {
+	s := phishtanktest.NewServer("http://evil.example/login")
+	defer s.Close()
+
+	dir := t.TempDir()
+
+	newDB := func(apiKey string, feed string) *phishtank.DB {
+		return phishtank.New(
+			phishtank.WithDataURL(s.URL+"/data/{apiKey}/{feed}.json.bz2"),
+			phishtank.WithHTTPClient(s.Client()),
+			phishtank.WithCredentials("test", apiKey),
+			phishtank.WithFeed(feed),
+			phishtank.WithCacheDir(dir),
+		)
+	}
+
+	load(t, newDB("key", "online-valid"))
+
+	// The cache is loaded by a DB of the same feed, even with another key.
+	db := newDB("other", "online-valid")
+
+	err := db.LoadCache()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found := db.Search([]string{"http://evil.example/login"}); len(found) != 1 {
+		t.Error("cache of the same feed not loaded")
+	}
+
+	// But not by one of another feed variant.
+	db = newDB("key", "online")
+
+	err = db.LoadCache()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if db.Ready() {
+		t.Error("cache of another feed variant loaded")
+	}
+}
+
+func TestLastChangedWithVersion(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	s := phishtanktest.NewServer("http://evil.example/login")
+	defer s.Close()
+
+	dir := t.TempDir()
+	extra := writeList(t, dir, "extra.txt", "http://extra.example/")
+	db := newTestDB(s, phishtank.WithExtraList(extra), phishtank.WithClock(clock))
+
+	// step advances the clock, runs change and a load, and checks whether the
+	// version and LastChanged moved together.
+	step := func(name string, change func(), want bool) {
+		t.Helper()
+
+		version := db.Version()
+		now = now.Add(time.Hour)
+		change()
+		load(t, db)
+
+		if changed := db.Version() != version; changed != want {
+			t.Errorf("%s: version changed = %v, want %v", name, changed, want)
+		}
+
+		if changed := db.Stats().LastChanged.Equal(now); changed != want {
+			t.Errorf("%s: LastChanged = %v, changed = %v, want %v", name, db.Stats().LastChanged, changed, want)
+		}
+	}
+
+	load(t, db)
+	step("unchanged", func() {}, false)
+	step("target changed", func() {
+		s.SetPhishes(phishtank.Phish{PhishID: "1", URL: "http://evil.example/login", Target: "PayPal", Verified: "yes", Online: "yes"})
+	}, true)
+	step("extra list changed", func() {
+		writeList(t, dir, "extra.txt", "http://extra.example/", "http://more.example/")
+	}, true)
+}
+
+func TestOnChangeNotAfterFailedLoad(t *testing.T) {
+	s := phishtanktest.NewServer("http://evil.example/login")
+	defer s.Close()
+
+	changes := 0
+	missing := filepath.Join(t.TempDir(), "missing.txt")
+	db := newTestDB(s, phishtank.WithAllowlist(missing), phishtank.WithOnChange(func(phishtank.Change) {
+		changes++
+	}))
+
+	if db.Load(context.Background()) == nil {
+		t.Fatal("Load succeeded with a missing allowlist")
+	}
+
+	if changes != 0 {
+		t.Errorf("%d changes notified after a failed load, want 0", changes)
+	}
+}
@@ -0,0 +1,323 @@
+package phishtank
+
+import (
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// normalizer controls how URLs are canonicalized into database keys. The zero
+// value lowercases, drops the scheme's default port and trims a trailing slash
+// from the path.
+type normalizer struct {
+	// ignoreScheme drops the scheme so that http and https URLs match each
+	// other. Phishing kits are often served over both, but this can match a
+	// URL that was only ever malicious over one of them.
+	ignoreScheme bool
+
+	// ignoreQuery and ignoreFragment drop the query string and fragment, which
+	// phishing kits often fill with per-victim values. This can match a URL
+	// whose query is what made it malicious.
+	ignoreQuery    bool
+	ignoreFragment bool
+
+	// ignoreWWW drops a leading "www." label from the host. This can match a
+	// site whose www and bare hosts are run by different parties.
+	ignoreWWW bool
+}
+
+// normalize returns the key under which url is stored in and looked up from
+// the database.
+func (n normalizer) normalize(url string) string {
+	key := trimTrailingSlash(strings.ToLower(decodeUnreserved(url)))
+	key = stripDefaultPort(key)
+	key = replaceHost(key, n.host)
+
+	if n.ignoreFragment {
+		key = stripFragment(key)
+	}
+
+	if n.ignoreQuery {
+		key = stripQuery(key)
+	}
+
+	if n.ignoreScheme {
+		key = stripScheme(key)
+	}
+
+	return key
+}
+
+// hostname returns the normalized hostname of url, or "" if it has none.
+func (n normalizer) hostname(url string) string {
+	return n.host(hostname(url))
+}
+
+// host normalizes a lowercased hostname.
+func (n normalizer) host(host string) string {
+	host = asciiHost(host)
+
+	if n.ignoreWWW {
+		host = strings.TrimPrefix(host, "www.")
+	}
+
+	return host
+}
+
+// schemeEnd returns the index just past the "://" following the scheme of url,
+// or 0 if there is none.
+func schemeEnd(url string) int {
+	if i := strings.Index(url, "://"); i >= 0 {
+		return i + len("://")
+	}
+
+	return 0
+}
+
+// hostSpan returns the start and end of the host in url, excluding any userinfo
+// and port.
+func hostSpan(url string) (int, int) {
+	start := schemeEnd(url)
+	end := len(url)
+
+	if i := strings.IndexAny(url[start:], "/?#"); i >= 0 {
+		end = start + i
+	}
+
+	if i := strings.LastIndexByte(url[start:end], '@'); i >= 0 {
+		start += i + 1
+	}
+
+	if strings.HasPrefix(url[start:end], "[") {
+		if i := strings.IndexByte(url[start:end], ']'); i >= 0 {
+			end = start + i + 1
+		}
+	} else if i := strings.LastIndexByte(url[start:end], ':'); i >= 0 {
+		end = start + i
+	}
+
+	return start, end
+}
+
+// defaultPorts are the ports that a URL of each scheme is on unless it says
+// otherwise.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// stripDefaultPort removes the port from a lowercased url if it's the default
+// for its scheme, or empty, so that http://host:80/ matches http://host/.
+// Other ports are kept.
+func stripDefaultPort(url string) string {
+	_, end := hostSpan(url)
+
+	if end == len(url) || url[end] != ':' {
+		return url
+	}
+
+	portEnd := len(url)
+
+	if i := strings.IndexAny(url[end:], "/?#"); i >= 0 {
+		portEnd = end + i
+	}
+
+	port := url[end+1 : portEnd]
+	scheme := strings.TrimSuffix(url[:schemeEnd(url)], "://")
+
+	if port == "" || port == defaultPorts[scheme] {
+		return url[:end] + url[portEnd:]
+	}
+
+	return url
+}
+
+// replaceHost returns url with its host replaced by f applied to it.
+func replaceHost(url string, f func(host string) string) string {
+	start, end := hostSpan(url)
+	return url[:start] + f(url[start:end]) + url[end:]
+}
+
+// asciiHost converts an internationalized hostname to its ASCII (punycode)
+// form, so that it matches however it was written.
+func asciiHost(host string) string {
+	if !hasNonASCII(host) {
+		return host
+	}
+
+	ascii, err := idna.ToASCII(host)
+
+	if err != nil {
+		return host
+	}
+
+	return ascii
+}
+
+func hasNonASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stripScheme removes the scheme and "://" from url.
+func stripScheme(url string) string {
+	return url[schemeEnd(url):]
+}
+
+// pathSpan returns the start and end of the path in url, excluding any query
+// or fragment.
+func pathSpan(url string) (int, int) {
+	start := schemeEnd(url)
+	i := strings.IndexAny(url[start:], "/?#")
+
+	if i < 0 {
+		return len(url), len(url)
+	}
+
+	start += i
+
+	if url[start] != '/' {
+		return start, start
+	}
+
+	end := len(url)
+
+	if i := strings.IndexAny(url[start:], "?#"); i >= 0 {
+		end = start + i
+	}
+
+	return start, end
+}
+
+// stripQuery removes the query string from url, keeping any fragment.
+func stripQuery(url string) string {
+	_, end := pathSpan(url)
+
+	if end == len(url) || url[end] != '?' {
+		return url
+	}
+
+	if i := strings.IndexByte(url[end:], '#'); i >= 0 {
+		return url[:end] + url[end+i:]
+	}
+
+	return url[:end]
+}
+
+// stripFragment removes the fragment from url.
+func stripFragment(url string) string {
+	if i := strings.IndexByte(url, '#'); i >= 0 {
+		return url[:i]
+	}
+
+	return url
+}
+
+// trimTrailingSlash removes a single trailing slash from the path of url, so
+// that http://host/login/ and http://host/login are equivalent. A bare root
+// path is left alone.
+func trimTrailingSlash(url string) string {
+	start, end := pathSpan(url)
+
+	if end-start > 1 && url[end-1] == '/' {
+		return url[:end-1] + url[end:]
+	}
+
+	return url
+}
+
+// decodeUnreserved decodes the percent-encoded unreserved characters (letters,
+// digits, "-", ".", "_" and "~") in the path of url, which mean the same
+// whether encoded or not. Other escapes, such as %2F, are left alone since
+// decoding them would change the meaning of the path.
+func decodeUnreserved(url string) string {
+	start, end := pathSpan(url)
+
+	if !strings.Contains(url[start:end], "%") {
+		return url
+	}
+
+	var b strings.Builder
+
+	b.WriteString(url[:start])
+
+	for i := start; i < end; i++ {
+		if url[i] == '%' && i+2 < end {
+			if c, ok := unhex(url[i+1], url[i+2]); ok && isUnreserved(c) {
+				b.WriteByte(c)
+				i += 2
+				continue
+			}
+		}
+
+		b.WriteByte(url[i])
+	}
+
+	b.WriteString(url[end:])
+
+	return b.String()
+}
+
+func unhex(hi byte, lo byte) (byte, bool) {
+	value := func(c byte) (byte, bool) {
+		switch {
+		case '0' <= c && c <= '9':
+			return c - '0', true
+		case 'a' <= c && c <= 'f':
+			return c - 'a' + 10, true
+		case 'A' <= c && c <= 'F':
+			return c - 'A' + 10, true
+		}
+
+		return 0, false
+	}
+
+	h, ok := value(hi)
+
+	if !ok {
+		return 0, false
+	}
+
+	l, ok := value(lo)
+
+	if !ok {
+		return 0, false
+	}
+
+	return h<<4 | l, true
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// hostname returns the lowercased hostname of url, or "" if it has none. A
+// bare hostname without a scheme is accepted as well.
+func hostname(rawURL string) string {
+	if schemeEnd(rawURL) == 0 {
+		rawURL = "http://" + rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(u.Hostname())
+}
+
+// ValidURL reports whether rawURL is a valid absolute URL, with a scheme and a
+// host.
+func ValidURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
@@ -0,0 +1,97 @@
+package phishtank
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeInternationalizedHost(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://bücher.example/", "http://xn--bcher-kva.example/"},
+		{"http://BÜCHER.example/login", "http://xn--bcher-kva.example/login"},
+		{"http://xn--bcher-kva.example/login", "http://xn--bcher-kva.example/login"},
+		{"http://user@bücher.example:8080/login", "http://user@xn--bcher-kva.example:8080/login"},
+		{"http://shop.bücher.example/", "http://shop.xn--bcher-kva.example/"},
+		// Only the host is converted; the path and query keep their Unicode.
+		{"http://bücher.example/bücher?q=ü", "http://xn--bcher-kva.example/bücher?q=ü"},
+		{"http://plain.example/bücher", "http://plain.example/bücher"},
+	}
+
+	for _, test := range tests {
+		if got := (normalizer{}).normalize(test.url); got != test.want {
+			t.Errorf("normalize(%q) = %q, want %q", test.url, got, test.want)
+		}
+	}
+}
+
+func TestSearchInternationalizedHost(t *testing.T) {
+	tests := []struct {
+		feed   string
+		search string
+	}{
+		{"http://xn--bcher-kva.example/login", "http://bücher.example/login"},
+		{"http://bücher.example/login", "http://xn--bcher-kva.example/login"},
+		{"http://bücher.example/bücher", "http://BÜCHER.example/bücher"},
+	}
+
+	for _, test := range tests {
+		d := New()
+
+		_, err := d.read(strings.NewReader(`[{"phish_id": 1, "url": "`+test.feed+`"}]`), validators{}, time.Now())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if found := d.Search([]string{test.search}); len(found) != 1 {
+			t.Errorf("feed %q: Search(%q) = %q, want a match", test.feed, test.search, found)
+		}
+	}
+
+	// The path isn't converted, so a punycoded path doesn't match a Unicode one.
+	d := New()
+
+	_, err := d.read(strings.NewReader(`[{"phish_id": 1, "url": "http://plain.example/bücher"}]`), validators{}, time.Now())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found := d.Search([]string{"http://plain.example/xn--bcher-kva"}); len(found) != 0 {
+		t.Errorf("Search of a punycoded path = %q, want no match", found)
+	}
+}
+
+func TestStripDefaultPort(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://h:80/login", "http://h/login"},
+		{"https://h:443/login", "https://h/login"},
+		{"http://h:/login", "http://h/login"},
+		{"http://h:80", "http://h"},
+		{"http://h:80?q=1", "http://h?q=1"},
+		{"http://user:pass@h:80/", "http://user:pass@h/"},
+		{"http://h/login", "http://h/login"},
+		// A port that's the default for the other scheme, or for none, is kept.
+		{"https://h:80/login", "https://h:80/login"},
+		{"http://h:443/login", "http://h:443/login"},
+		{"http://h:8080/login", "http://h:8080/login"},
+		{"ftp://h:80/file", "ftp://h:80/file"},
+		{"http://[::1]:80/login", "http://[::1]/login"},
+		{"https://[::1]:443/", "https://[::1]/"},
+		{"http://[::1]:8080/login", "http://[::1]:8080/login"},
+		{"http://[::1]/login", "http://[::1]/login"},
+	}
+
+	for _, test := range tests {
+		if got := stripDefaultPort(test.url); got != test.want {
+			t.Errorf("stripDefaultPort(%q) = %q, want %q", test.url, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package phishtank
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// LoadOpenPhish refreshes the URLs from the OpenPhish feed, a plain list of
+// URLs one per line, which are matched alongside those from Phishtank. It does
+// nothing unless an OpenPhish URL was configured with WithOpenPhish.
+func (d *DB) LoadOpenPhish(ctx context.Context) error {
+	if d.openPhishURL == "" {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.openPhishURL, nil)
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("User-Agent", d.userAgentHeader())
+
+	res, err := d.client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad status fetching %s: %v", req.URL, res.StatusCode)
+	}
+
+	body, err := decompress(res.Body)
+
+	if err != nil {
+		return err
+	}
+
+	list, err := d.parseList(body)
+
+	if err != nil {
+		return err
+	}
+
+	// The list is kept on the DB as well as in the snapshot so that a
+	// Phishtank load, which builds a new snapshot, carries it over.
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	changed := !list.equal(d.openPhish)
+	d.openPhish = list
+
+	if current := d.current.Load(); current != nil {
+		snap := *current
+		snap.openPhish = list
+		d.storeLocked(&snap, changed, d.now())
+	}
+
+	return nil
+}
+
+// openPhishCount returns the number of URLs from the OpenPhish feed.
+func (d *DB) openPhishCount() int {
+	if d.openPhish == nil {
+		return 0
+	}
+
+	return len(d.openPhish.urls)
+}
@@ -0,0 +1,249 @@
+package phishtank
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// An Option configures a DB created by New.
+type Option func(*DB)
+
+// WithCredentials sets the Phishtank username and API keys used to fetch the
+// feed. If there are several keys, each is tried in turn until one succeeds.
+func WithCredentials(username string, apiKeys ...string) Option {
+	return func(d *DB) {
+		d.username = username
+		d.apiKeys = apiKeys
+	}
+}
+
+// WithLogger sets the logger the DB reports fetch failures to, in place of
+// slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(d *DB) {
+		d.logger = logger
+	}
+}
+
+// WithUserAgent sets the User-Agent sent with feed requests, in place of the
+// default of "phishtank/" followed by the username.
+func WithUserAgent(userAgent string) Option {
+	return func(d *DB) {
+		d.userAgent = userAgent
+	}
+}
+
+// WithDataURL sets the URL the feed is fetched from, with {apiKey} replaced by
+// the API key and {feed} by the feed variant. It defaults to DefaultDataURL.
+func WithDataURL(dataURL string) Option {
+	return func(d *DB) {
+		d.dataURL = dataURL
+	}
+}
+
+// WithFeed sets the variant of the feed to fetch, one of Feeds, in place of
+// DefaultFeed.
+func WithFeed(feed string) Option {
+	return func(d *DB) {
+		d.feed = feed
+	}
+}
+
+// WithHTTPClient fetches the feed with client instead of a client of the DB's
+// own, for instance to use a custom transport or a test server.
+func WithHTTPClient(client *http.Client) Option {
+	return func(d *DB) {
+		d.client = client
+	}
+}
+
+// WithHEADCheck compares the feed's ETag with a HEAD request before fetching
+// it, rather than making a conditional GET, for mirrors that don't support
+// conditional requests.
+func WithHEADCheck(headCheck bool) Option {
+	return func(d *DB) {
+		d.headCheck = headCheck
+	}
+}
+
+// WithFetchTimeout sets how long to wait for the feed to download. It applies
+// to a copy of the HTTP client, leaving one given to WithHTTPClient untouched.
+func WithFetchTimeout(timeout time.Duration) Option {
+	return func(d *DB) {
+		client := *d.client
+		client.Timeout = timeout
+		d.client = &client
+	}
+}
+
+// WithClock sets the function the DB calls for the current time, in place of
+// time.Now, so that tests can control timestamps and ages.
+func WithClock(now func() time.Time) Option {
+	return func(d *DB) {
+		d.now = now
+	}
+}
+
+// WithFile loads the feed from a local file instead of fetching it.
+func WithFile(path string) Option {
+	return func(d *DB) {
+		d.file = path
+	}
+}
+
+// WithExtraList adds the URLs listed in the file at path, one per line, to
+// those from the feed. The file is re-read on every load.
+func WithExtraList(path string) Option {
+	return func(d *DB) {
+		d.extraList = path
+	}
+}
+
+// WithAllowlist suppresses matches of the URLs listed in the file at path, one
+// per line, whether they come from the feed or the extra list. The file is
+// re-read on every load.
+func WithAllowlist(path string) Option {
+	return func(d *DB) {
+		d.allowlist = path
+	}
+}
+
+// WithOpenPhish matches URLs from the OpenPhish feed at url as well as from
+// Phishtank. LoadOpenPhish fetches it.
+func WithOpenPhish(url string) Option {
+	return func(d *DB) {
+		d.openPhishURL = url
+	}
+}
+
+// WithCacheDir saves each fetched feed in dir, from which LoadCache restores
+// it.
+func WithCacheDir(dir string) Option {
+	return func(d *DB) {
+		d.cacheDir = dir
+	}
+}
+
+// WithBloomFilter holds the URLs in a Bloom filter with the given
+// false-positive rate rather than a map. This saves memory, but searches
+// return no feed details beyond the URL and changes between loads can't be
+// listed.
+func WithBloomFilter(falsePositiveRate float64) Option {
+	return func(d *DB) {
+		d.bloomRate = falsePositiveRate
+	}
+}
+
+// WithIgnoreScheme treats http and https URLs as equivalent when matching.
+func WithIgnoreScheme(ignore bool) Option {
+	return func(d *DB) {
+		d.normalizer.ignoreScheme = ignore
+	}
+}
+
+// WithIgnoreQuery ignores the query string when matching.
+func WithIgnoreQuery(ignore bool) Option {
+	return func(d *DB) {
+		d.normalizer.ignoreQuery = ignore
+	}
+}
+
+// WithIgnoreFragment ignores the fragment when matching.
+func WithIgnoreFragment(ignore bool) Option {
+	return func(d *DB) {
+		d.normalizer.ignoreFragment = ignore
+	}
+}
+
+// WithIgnoreWWW ignores a leading "www." on hosts when matching.
+func WithIgnoreWWW(ignore bool) Option {
+	return func(d *DB) {
+		d.normalizer.ignoreWWW = ignore
+	}
+}
+
+// WithIndex indexes the feed's URLs by hostname and target for URLsByHost and
+// URLsByTarget. This costs memory of the order of a second copy of the URLs.
+func WithIndex(index bool) Option {
+	return func(d *DB) {
+		d.index = index
+	}
+}
+
+// WithVerifiedOnly leaves out feed entries that aren't verified as phishing,
+// for feeds such as "online" that include them.
+func WithVerifiedOnly(verifiedOnly bool) Option {
+	return func(d *DB) {
+		d.verifiedOnly = verifiedOnly
+	}
+}
+
+// WithOnlineOnly leaves out feed entries that aren't marked as online.
+func WithOnlineOnly(onlineOnly bool) Option {
+	return func(d *DB) {
+		d.onlineOnly = onlineOnly
+	}
+}
+
+// WithMatchSubdomains makes SearchDomains match a hostname that is a
+// subdomain of one in the database, as well as one that is in it, since
+// phishing sites often spread over many subdomains of a flagged domain. This
+// can match unrelated sites when a shared domain, such as a hosting provider's
+// or a public suffix, has itself been listed.
+func WithMatchSubdomains(match bool) Option {
+	return func(d *DB) {
+		d.matchSubdomains = match
+	}
+}
+
+// WithPathPrefix also matches a URL when the database has one with the same
+// host whose path is a prefix of its path, ending at a "/", since phishing kits
+// often lead victims on from a listed page to others below it. A URL listed at
+// the root of its host makes every URL on the host match, so this can match
+// legitimate pages of a compromised site.
+func WithPathPrefix(match bool) Option {
+	return func(d *DB) {
+		d.matchPathPrefix = match
+	}
+}
+
+// WithMinEntries refuses to load a feed with fewer than n entries, keeping
+// the current data instead. Until a feed that large loads, the DB stays empty.
+func WithMinEntries(n int) Option {
+	return func(d *DB) {
+		d.minEntries = n
+	}
+}
+
+// WithMaxShrink refuses to load a feed with more than percent fewer entries
+// than the current one, keeping the current data instead. A feed that shrinks
+// that much is more likely truncated than genuine. Zero disables the check.
+func WithMaxShrink(percent float64) Option {
+	return func(d *DB) {
+		d.maxShrink = percent
+	}
+}
+
+// WithDrain makes Draining report true for window after each load replaces
+// the data, so that a server can briefly turn searches away across the swap.
+func WithDrain(window time.Duration) Option {
+	return func(d *DB) {
+		d.drain = window
+	}
+}
+
+// WithMaxDiff sets how many of the URLs added and removed by a load are kept
+// for Diff. It defaults to 1000.
+func WithMaxDiff(n int) Option {
+	return func(d *DB) {
+		d.maxDiff = n
+	}
+}
+
+// WithOnChange calls fn after each load that changes the database.
+func WithOnChange(fn func(Change)) Option {
+	return func(d *DB) {
+		d.onChange = fn
+	}
+}
@@ -0,0 +1,988 @@
+// Package phishtank checks URLs against the Phishtank feed of known phishing
+// sites, held in memory and refreshed on demand.
+package phishtank
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultDataURL is the location of the Phishtank feed, with {apiKey} standing
+// in for the API key and {feed} for the feed variant.
+const DefaultDataURL = "https://data.phishtank.com/data/{apiKey}/{feed}.json.bz2"
+
+// DefaultFeed is the feed variant fetched unless configured otherwise: the
+// verified phishing sites that are online.
+const DefaultFeed = "online-valid"
+
+// Feeds are the known variants of the Phishtank feed. Besides DefaultFeed,
+// "online" covers more sites at the cost of including unverified ones.
+var Feeds = []string{DefaultFeed, "online"}
+
+// ValidFeed reports whether feed is one of Feeds.
+func ValidFeed(feed string) bool {
+	for _, known := range Feeds {
+		if feed == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DefaultFetchTimeout is how long a DB waits for the feed to download unless
+// configured otherwise.
+const DefaultFetchTimeout = 5 * time.Minute
+
+// Phish is a Phishtank feed record of a phishing URL.
+type Phish struct {
+	PhishID          json.Number `json:"phish_id"`
+	URL              string      `json:"url"`
+	Target           string      `json:"target"`
+	Verified         string      `json:"verified"`
+	VerificationTime string      `json:"verification_time"`
+	Online           string      `json:"online"`
+}
+
+// snapshot is the set of URLs from one load of the feed, along with any extra
+// list, allowlist and OpenPhish feed. Each load builds a new snapshot and
+// swaps it in atomically, so searches can read it without locking.
+type snapshot struct {
+	urls       map[string]Phish
+	filter     *bloomFilter
+	hosts      map[string]struct{}
+	count      int
+	extra      *urlList
+	allowed    *urlList
+	openPhish  *urlList
+	listCount  int
+	suppressed int
+	byHost     map[string][]string
+	byTarget   map[string][]string
+	version    string
+}
+
+// get returns the record for the URL with the given normalized key, if it's
+// present in the feed, the extra list or the OpenPhish feed and not
+// allowlisted.
+func (s *snapshot) get(key string, url string) (Phish, bool) {
+	if _, allowed := s.allowed.get(key); allowed {
+		return Phish{}, false
+	}
+
+	p, present := s.getFeed(key, url)
+
+	if !present {
+		p, present = s.extra.get(key)
+	}
+
+	if !present {
+		p, present = s.openPhish.get(key)
+	}
+
+	return p, present
+}
+
+// getPrefix is like get but looks for the URL's path prefixes instead: its
+// path without any query or fragment, then each shorter path ending at a "/",
+// down to the root. The longest present is returned along with its key, so
+// that http://evil.example/kit/step2 is found under http://evil.example/kit.
+func (s *snapshot) getPrefix(key string, url string) (Phish, string, bool) {
+	start, end := pathSpan(key)
+
+	if end > start && end < len(key) {
+		if p, present := s.get(key[:end], url); present {
+			return p, key[:end], true
+		}
+	}
+
+	for i := end - 1; i > start; i-- {
+		if key[i] != '/' {
+			continue
+		}
+
+		if p, present := s.get(key[:i], url); present {
+			return p, key[:i], true
+		}
+	}
+
+	// The root is tried both with its slash and bare, as the feed may list a
+	// host either way and normalization keeps whichever it was given.
+	for _, prefix := range []string{key[:start] + "/", key[:start]} {
+		if p, present := s.get(prefix, url); present {
+			return p, prefix, true
+		}
+	}
+
+	return Phish{}, "", false
+}
+
+// getFeed is like get but only looks in the feed.
+func (s *snapshot) getFeed(key string, url string) (Phish, bool) {
+	if s.filter != nil {
+		return Phish{URL: url}, s.filter.has(key)
+	}
+
+	p, present := s.urls[key]
+	return p, present
+}
+
+// hasHostOrParent is like hasHost but also reports whether any parent domain
+// of host is such a hostname, so that login.evil.example matches evil.example.
+// Each parent is looked up in turn, shortest last.
+func (s *snapshot) hasHostOrParent(host string) bool {
+	for host != "" {
+		if s.hasHost(host) {
+			return true
+		}
+
+		_, host, _ = strings.Cut(host, ".")
+	}
+
+	return false
+}
+
+// hasHost reports whether host is the hostname of any URL in the feed, the
+// extra list or the OpenPhish feed.
+func (s *snapshot) hasHost(host string) bool {
+	_, present := s.hosts[host]
+
+	for _, list := range []*urlList{s.extra, s.openPhish} {
+		if !present && list != nil {
+			_, present = list.hosts[host]
+		}
+	}
+
+	return present
+}
+
+// DB is an in-memory copy of the Phishtank feed. It is empty until loaded,
+// and safe for concurrent use.
+type DB struct {
+	username           string
+	apiKeys            []string
+	logger             *slog.Logger
+	userAgent          string
+	dataURL            string
+	feed               string
+	client             *http.Client
+	now                func() time.Time
+	file               string
+	extraList          string
+	allowlist          string
+	openPhishURL       string
+	openPhish          *urlList
+	cacheDir           string
+	bloomRate          float64
+	normalizer         normalizer
+	lastUpdated        time.Time
+	lastChanged        time.Time
+	lastRefreshAttempt time.Time
+	lastRefreshed      time.Time
+	lastRefreshError   string
+	refreshCount       int64
+	refreshErrorCount  int64
+	validators         validators
+	headCheck          bool
+	index              bool
+	matchSubdomains    bool
+	matchPathPrefix    bool
+	verifiedOnly       bool
+	onlineOnly         bool
+	minEntries         int
+	maxShrink          float64
+	refreshHint        time.Duration
+	drain              time.Duration
+	drainUntil         int64
+	current            atomic.Pointer[snapshot]
+	generation         uint64
+	added              int
+	removed            int
+	addedURLs          []string
+	removedURLs        []string
+	maxDiff            int
+	onChange           func(Change)
+	mutex              sync.RWMutex
+	loadMutex          sync.Mutex
+	loading            *loadCall
+	queued             *loadCall
+	searchCount        int64
+	searchURLCount     int64
+	hitURLCount        int64
+	targetHits         map[string]int64
+	targetHitsMutex    sync.Mutex
+	ready              int32
+}
+
+// New returns an empty DB configured by opts. Call Load to fill it.
+func New(opts ...Option) *DB {
+	d := &DB{
+		dataURL: DefaultDataURL,
+		feed:    DefaultFeed,
+		client:  &http.Client{Timeout: DefaultFetchTimeout},
+		logger:  slog.Default(),
+		now:     time.Now,
+		maxDiff: 1000,
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d
+}
+
+func (d *DB) newRequest(ctx context.Context, method string, apiKey string) (*http.Request, error) {
+	url := strings.NewReplacer("{apiKey}", apiKey, "{feed}", d.feed).Replace(d.dataURL)
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", d.userAgentHeader())
+	return req, nil
+}
+
+// userAgentHeader returns the User-Agent to send with feed requests.
+func (d *DB) userAgentHeader() string {
+	if d.userAgent != "" {
+		return d.userAgent
+	}
+
+	return "phishtank/" + d.username
+}
+
+// loadCall is a load in progress, whose result concurrent callers share.
+type loadCall struct {
+	done chan struct{}
+	err  error
+}
+
+// Load refreshes the database from the feed. A call made while a load is in
+// progress, which may have fetched the feed before whatever prompted the call,
+// queues another load to follow it, and waits for that one and returns its
+// result. Calls made meanwhile share the queued load rather than queuing more.
+func (d *DB) Load(ctx context.Context) error {
+	d.loadMutex.Lock()
+
+	if d.loading != nil {
+		if d.queued == nil {
+			d.queued = &loadCall{done: make(chan struct{})}
+		}
+
+		call := d.queued
+		d.loadMutex.Unlock()
+
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	call := &loadCall{done: make(chan struct{})}
+	d.loading = call
+	d.loadMutex.Unlock()
+
+	err := d.loadNow(ctx)
+	result := err
+
+	// A load queued meanwhile is run here too, under ctx, and so on until
+	// none is.
+	for call != nil {
+		call.err = err
+
+		d.loadMutex.Lock()
+		next := d.queued
+		d.queued = nil
+		d.loading = next
+		d.loadMutex.Unlock()
+		close(call.done)
+
+		if next != nil {
+			err = d.loadNow(ctx)
+		}
+
+		call = next
+	}
+
+	return result
+}
+
+func (d *DB) loadNow(ctx context.Context) error {
+	changed, err := d.fetch(ctx)
+
+	var listErr error
+
+	if d.extraList != "" || d.allowlist != "" {
+		listErr = d.loadLists()
+
+		if err == nil {
+			err = listErr
+		}
+	}
+
+	d.mutex.Lock()
+	d.lastRefreshAttempt = d.now()
+	d.refreshCount++
+	d.lastRefreshError = ""
+
+	// A load that finds the feed unchanged refreshes it as much as one that
+	// downloads a new one.
+	if err == nil {
+		d.lastRefreshed = d.lastRefreshAttempt
+	}
+
+	if err != nil {
+		d.refreshErrorCount++
+		d.lastRefreshError = err.Error()
+	}
+
+	d.mutex.Unlock()
+
+	// A changed feed that only failed to be cached was loaded successfully,
+	// but a load whose lists failed wasn't.
+	if changed && listErr == nil && d.onChange != nil {
+		d.onChange(d.LastChange())
+	}
+
+	return err
+}
+
+// fetch loads the feed if it has changed, reporting whether that changed the
+// database. Each API key is tried in turn until one succeeds. Keys are logged
+// by their position rather than their value.
+func (d *DB) fetch(ctx context.Context) (bool, error) {
+	if d.file != "" {
+		return d.readFile(d.file, validators{})
+	}
+
+	apiKeys := d.apiKeys
+
+	if len(apiKeys) == 0 {
+		apiKeys = []string{""}
+	}
+
+	var err error
+
+	for i, apiKey := range apiKeys {
+		var changed bool
+
+		changed, err = d.fetchWith(ctx, apiKey)
+
+		// A changed feed was loaded even if saving it to the cache failed.
+		if err == nil || changed {
+			if len(apiKeys) > 1 {
+				d.logger.Info("Fetched feed", "apiKey", i+1)
+			}
+
+			return changed, err
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		if i < len(apiKeys)-1 {
+			d.logger.Warn("Error fetching feed, trying next API key", "apiKey", i+1, "error", err)
+		}
+	}
+
+	return false, err
+}
+
+// validators identify a version of the feed, so that a conditional request
+// only downloads it if it has changed since. Servers prefer the ETag when
+// both are sent.
+type validators struct {
+	eTag         string
+	lastModified string
+}
+
+// fetchWith is fetch using a single API key. The feed is fetched with a
+// conditional GET, unless headCheck is set, in which case a HEAD request for
+// its ETag decides whether to fetch it, for mirrors that ignore conditional
+// requests.
+func (d *DB) fetchWith(ctx context.Context, apiKey string) (bool, error) {
+	if d.headCheck && d.validators.eTag != "" {
+		req, err := d.newRequest(ctx, http.MethodHead, apiKey)
+
+		if err != nil {
+			return false, err
+		}
+
+		res, err := d.client.Do(req)
+
+		if err != nil {
+			return false, err
+		}
+
+		defer res.Body.Close()
+
+		if res.Header.Get("ETag") == d.validators.eTag {
+			d.setRefreshHint(res.Header)
+			return false, nil
+		}
+	}
+
+	req, err := d.newRequest(ctx, http.MethodGet, apiKey)
+
+	if err != nil {
+		return false, err
+	}
+
+	if d.validators.eTag != "" {
+		req.Header.Set("If-None-Match", d.validators.eTag)
+	}
+
+	if d.validators.lastModified != "" {
+		req.Header.Set("If-Modified-Since", d.validators.lastModified)
+	}
+
+	res, err := d.client.Do(req)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified {
+		d.setRefreshHint(res.Header)
+		return false, nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("bad status fetching %s: %v", req.URL, res.StatusCode)
+	}
+
+	var body io.Reader = res.Body
+
+	// The transport only decodes gzip itself if it asked for it, so a mirror
+	// that compresses regardless is handled here.
+	if strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") && !res.Uncompressed {
+		gz, err := gzip.NewReader(body)
+
+		if err != nil {
+			return false, err
+		}
+
+		body = gz
+	}
+
+	var cache *os.File
+
+	if d.cacheDir != "" {
+		cache, err = os.CreateTemp(d.cacheDir, "feed-*")
+
+		if err != nil {
+			return false, err
+		}
+
+		defer os.Remove(cache.Name())
+		defer cache.Close()
+		body = io.TeeReader(body, cache)
+	}
+
+	v := validators{
+		eTag:         res.Header.Get("ETag"),
+		lastModified: res.Header.Get("Last-Modified"),
+	}
+	changed, err := d.read(body, v, d.now())
+
+	if err == nil {
+		d.setRefreshHint(res.Header)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if cache != nil {
+		err = d.saveCache(body, cache, v)
+
+		if err != nil {
+			return changed, fmt.Errorf("error writing cache: %v", err)
+		}
+	}
+
+	return changed, nil
+}
+
+// readFile loads the database from the feed stored at path, dated by the
+// file's modification time, reporting whether that changed it.
+func (d *DB) readFile(path string, v validators) (bool, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+
+	if err != nil {
+		return false, err
+	}
+
+	return d.read(f, v, info.ModTime())
+}
+
+// read decodes a feed from r, which may be compressed, and replaces the
+// contents of the database with it, reporting whether that changed them.
+func (d *DB) read(r io.Reader, v validators, updated time.Time) (bool, error) {
+	r, err := decompress(r)
+
+	if err != nil {
+		return false, err
+	}
+
+	// The feed is decoded one entry at a time, so that only the map is ever
+	// held in full. A Bloom filter must be sized before anything is added, so
+	// for one just the keys are collected first. The current size is a good
+	// guess at the new one.
+	sizeHint := d.entryCount()
+
+	var urls map[string]Phish
+	var keys []string
+
+	if d.bloomRate > 0 {
+		keys = make([]string, 0, sizeHint)
+	} else {
+		urls = make(map[string]Phish, sizeHint)
+	}
+
+	hosts := make(map[string]struct{})
+	var byHost, byTarget map[string][]string
+
+	if d.index {
+		byHost = make(map[string][]string)
+		byTarget = make(map[string][]string)
+	}
+
+	dec := json.NewDecoder(r)
+	excluded := 0
+
+	err = expectDelim(dec, '[')
+
+	if err != nil {
+		return false, err
+	}
+
+	for dec.More() {
+		var phish Phish
+
+		err = dec.Decode(&phish)
+
+		if err != nil {
+			return false, err
+		}
+
+		if d.verifiedOnly && phish.Verified != "yes" || d.onlineOnly && phish.Online != "yes" {
+			excluded++
+			continue
+		}
+
+		key := d.normalizer.normalize(phish.URL)
+
+		if urls != nil {
+			urls[key] = phish
+		} else {
+			keys = append(keys, key)
+		}
+
+		host := d.normalizer.hostname(phish.URL)
+
+		if host != "" {
+			hosts[host] = struct{}{}
+		}
+
+		if d.index {
+			byHost[host] = append(byHost[host], phish.URL)
+			target := strings.ToLower(phish.Target)
+			byTarget[target] = append(byTarget[target], phish.URL)
+		}
+	}
+
+	err = expectDelim(dec, ']')
+
+	if err != nil {
+		return false, err
+	}
+
+	if excluded > 0 {
+		d.logger.Info("Excluded feed entries", "excluded", excluded, "verifiedOnly", d.verifiedOnly, "onlineOnly", d.onlineOnly)
+	}
+
+	snap := &snapshot{
+		urls:     urls,
+		hosts:    hosts,
+		count:    len(urls),
+		byHost:   byHost,
+		byTarget: byTarget,
+	}
+
+	if urls == nil {
+		snap.filter = newBloomFilter(len(keys), d.bloomRate)
+
+		// Feed URLs that normalize alike are counted once, as they are in a
+		// map. A false positive undercounts by one, at the filter's rate.
+		for _, key := range keys {
+			if !snap.filter.has(key) {
+				snap.filter.add(key)
+				snap.count++
+			}
+		}
+	}
+
+	var old map[string]Phish
+
+	oldCount := 0
+
+	// The lists are carried over until the load re-reads them.
+	if current := d.current.Load(); current != nil {
+		old = current.urls
+		oldCount = current.count
+		snap.extra = current.extra
+		snap.listCount = current.listCount
+		snap.allowed = current.allowed
+		snap.suppressed = current.suppressed
+	}
+
+	err = d.checkSize(snap.count, oldCount)
+
+	if err != nil {
+		return false, err
+	}
+
+	// The URL sets can only be compared when they're held in maps. At most
+	// maxDiff of the URLs added and removed are kept.
+	added, removed := 0, 0
+	addedURLs, removedURLs := make([]string, 0), make([]string, 0)
+	modified := false
+
+	if urls != nil {
+		for key, p := range urls {
+			if q, present := old[key]; !present {
+				added++
+
+				if len(addedURLs) < d.maxDiff {
+					addedURLs = append(addedURLs, p.URL)
+				}
+			} else if q != p {
+				modified = true
+			}
+		}
+
+		for key, p := range old {
+			if _, present := urls[key]; !present {
+				removed++
+
+				if len(removedURLs) < d.maxDiff {
+					removedURLs = append(removedURLs, p.URL)
+				}
+			}
+		}
+	}
+
+	d.validators = v
+	d.mutex.Lock()
+	snap.openPhish = d.openPhish
+	d.lastUpdated = updated
+
+	// A feed read from the cache was fresh when it was saved.
+	if updated.After(d.lastRefreshed) {
+		d.lastRefreshed = updated
+	}
+
+	// Without maps to compare, a Bloom filter's contents are assumed to have
+	// changed.
+	changed := added > 0 || removed > 0 || modified || urls == nil
+
+	d.storeLocked(snap, changed, updated)
+	d.added = added
+	d.removed = removed
+	d.addedURLs = addedURLs
+	d.removedURLs = removedURLs
+	d.mutex.Unlock()
+	atomic.StoreInt32(&d.ready, 1)
+
+	return changed, nil
+}
+
+// setRefreshHint records how long the feed response with header h says it
+// stays fresh.
+func (d *DB) setRefreshHint(h http.Header) {
+	hint := freshness(h, d.now())
+
+	d.mutex.Lock()
+	d.refreshHint = hint
+	d.mutex.Unlock()
+}
+
+// RefreshHint returns how long the last feed response said it stays fresh, by
+// its Cache-Control max-age or Expires header, or 0 if it didn't say.
+func (d *DB) RefreshHint() time.Duration {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.refreshHint
+}
+
+// freshness returns how long a response with header h stays fresh, received
+// at now: its Cache-Control max-age less its Age, or failing that the time
+// until it Expires. It returns 0 if the response gives neither or mustn't be
+// cached.
+func freshness(h http.Header, now time.Time) time.Duration {
+	maxAge := -1
+
+	for _, directive := range strings.Split(h.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+
+		switch strings.ToLower(name) {
+		case "no-store", "no-cache":
+			return 0
+		case "max-age":
+			if seconds, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil {
+				maxAge = seconds
+			}
+		}
+	}
+
+	if maxAge >= 0 {
+		age, _ := strconv.Atoi(h.Get("Age"))
+		return max(time.Duration(maxAge-age)*time.Second, 0)
+	}
+
+	expires, err := http.ParseTime(h.Get("Expires"))
+
+	if err != nil {
+		return 0
+	}
+
+	if date, err := http.ParseTime(h.Get("Date")); err == nil {
+		now = date
+	}
+
+	return max(expires.Sub(now), 0)
+}
+
+// storeLocked makes snap the current snapshot. If it replaces another whose
+// data it leaves the same, as changed reports, it keeps that one's version.
+// Otherwise the data is recorded as changed at the time given, it gets a new
+// version and, if it replaces another, the DB drains for the configured
+// window. The caller must hold the mutex.
+func (d *DB) storeLocked(snap *snapshot, changed bool, at time.Time) {
+	current := d.current.Load()
+
+	if current != nil && !changed {
+		snap.version = current.version
+		d.current.Store(snap)
+		return
+	}
+
+	if d.drain > 0 && current != nil {
+		atomic.StoreInt64(&d.drainUntil, d.now().Add(d.drain).UnixNano())
+	}
+
+	d.lastChanged = at
+	d.generation++
+	snap.version = fmt.Sprintf("%x-%x", d.lastUpdated.UnixNano(), d.generation)
+	d.current.Store(snap)
+}
+
+// Version returns an opaque string identifying the data currently loaded, or
+// "" if nothing is loaded. It changes whenever the data does, and is kept by
+// a reload that leaves the feed and lists the same. A feed held in a Bloom
+// filter can't be compared, so every load of one changes it.
+func (d *DB) Version() string {
+	if snap := d.current.Load(); snap != nil {
+		return snap.version
+	}
+
+	return ""
+}
+
+// expectDelim reads the next token from dec, returning an error unless it's
+// the delimiter delim.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+
+	if err != nil {
+		return err
+	}
+
+	if tok != delim {
+		return fmt.Errorf("feed is not a JSON array: expected %v, found %v", delim, tok)
+	}
+
+	return nil
+}
+
+// checkSize returns an error if a feed of count entries is too small to
+// replace one of oldCount entries: if it's empty, has fewer than minEntries,
+// or has shrunk by more than maxShrink percent. Such a feed is more likely to
+// be truncated or broken than genuine, and swapping it in would stop URLs
+// being detected.
+func (d *DB) checkSize(count int, oldCount int) error {
+	if count == 0 {
+		return fmt.Errorf("feed is empty; keeping the current %d entries", oldCount)
+	}
+
+	if count < d.minEntries {
+		return fmt.Errorf("feed has %d entries, fewer than the minimum of %d; keeping the current %d", count, d.minEntries, oldCount)
+	}
+
+	if d.maxShrink > 0 && oldCount > 0 {
+		shrink := 100 * float64(oldCount-count) / float64(oldCount)
+
+		if shrink > d.maxShrink {
+			return fmt.Errorf("feed has %d entries, %.1f%% fewer than the current %d; keeping them", count, shrink, oldCount)
+		}
+	}
+
+	return nil
+}
+
+// Change describes how a load changed the database.
+type Change struct {
+	LastUpdated time.Time `json:"lastUpdated"`
+	EntryCount  int       `json:"entryCount"`
+	Added       int       `json:"added"`
+	Removed     int       `json:"removed"`
+	Suppressed  int       `json:"suppressed"`
+}
+
+// LastChange returns how the last load changed the database.
+func (d *DB) LastChange() Change {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return Change{
+		LastUpdated: d.lastUpdated,
+		EntryCount:  d.entryCount(),
+		Added:       d.added,
+		Removed:     d.removed,
+		Suppressed:  d.suppressed(),
+	}
+}
+
+// Stats is a point-in-time summary of a DB's contents, refreshes and searches.
+type Stats struct {
+	LastUpdated        time.Time
+	LastChanged        time.Time
+	EntryCount         int
+	SearchCount        int64
+	SearchURLCount     int64
+	HitURLCount        int64
+	AddedLastRefresh   int
+	RemovedLastRefresh int
+	LastRefreshAttempt time.Time
+	LastRefreshError   string
+	RefreshCount       int64
+	RefreshErrorCount  int64
+	OpenPhishCount     int
+}
+
+// Stats returns the current statistics of the database.
+func (d *DB) Stats() Stats {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return Stats{
+		LastUpdated:        d.lastUpdated,
+		LastChanged:        d.lastChanged,
+		EntryCount:         d.entryCount(),
+		SearchCount:        atomic.LoadInt64(&d.searchCount),
+		SearchURLCount:     atomic.LoadInt64(&d.searchURLCount),
+		HitURLCount:        atomic.LoadInt64(&d.hitURLCount),
+		AddedLastRefresh:   d.added,
+		RemovedLastRefresh: d.removed,
+		LastRefreshAttempt: d.lastRefreshAttempt,
+		LastRefreshError:   d.lastRefreshError,
+		RefreshCount:       d.refreshCount,
+		RefreshErrorCount:  d.refreshErrorCount,
+		OpenPhishCount:     d.openPhishCount(),
+	}
+}
+
+// Diff lists the URLs added and removed by the last load. Truncated is set if
+// there were more than the DB keeps.
+type Diff struct {
+	Added     []string
+	Removed   []string
+	Truncated bool
+}
+
+// Diff returns the URLs added and removed by the last load.
+func (d *DB) Diff() Diff {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return Diff{
+		Added:     d.addedURLs,
+		Removed:   d.removedURLs,
+		Truncated: len(d.addedURLs) < d.added || len(d.removedURLs) < d.removed,
+	}
+}
+
+func (d *DB) entryCount() int {
+	if snap := d.current.Load(); snap != nil {
+		return snap.count + snap.listCount
+	}
+
+	return 0
+}
+
+// suppressed returns the number of allowlisted URLs present in the database.
+func (d *DB) suppressed() int {
+	if snap := d.current.Load(); snap != nil {
+		return snap.suppressed
+	}
+
+	return 0
+}
+
+// Age returns how long ago the database was last refreshed successfully,
+// whether that loaded a new feed or found it unchanged. A database that has
+// never been refreshed is as old as the feed it was loaded from.
+func (d *DB) Age() time.Duration {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.now().Sub(d.lastRefreshed)
+}
+
+// Draining reports whether the data was replaced less than the window given to
+// WithDrain ago. Callers that want every answer in a run of searches to come
+// from the same data can turn searches away while it does.
+func (d *DB) Draining() bool {
+	return d.now().UnixNano() < atomic.LoadInt64(&d.drainUntil)
+}
+
+// Ready reports whether the database has been loaded at least once.
+func (d *DB) Ready() bool {
+	return atomic.LoadInt32(&d.ready) == 1
+}
+
+// Updated returns when the database was last updated.
+func (d *DB) Updated() time.Time {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.lastUpdated
+}
@@ -0,0 +1,153 @@
+package phishtank
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadGzipContentEncoding(t *testing.T) {
+	bz2, err := os.ReadFile("phishtanktest/testdata/online-valid.json.bz2")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feeds := []struct {
+		name string
+		feed []byte
+		url  string
+	}{
+		{"bzip2", bz2, "https://www.bank.example/secure/update.php"},
+		{"JSON", []byte(`[{"phish_id": 1, "url": "http://evil.example/login"}]`), "http://evil.example/login"},
+	}
+
+	// The default transport asks for gzip and decodes it itself; one with
+	// compression disabled leaves the DB to decode it.
+	transports := []struct {
+		name      string
+		transport *http.Transport
+	}{
+		{"decoded by the transport", &http.Transport{}},
+		{"decoded by the DB", &http.Transport{DisableCompression: true}},
+	}
+
+	for _, feed := range feeds {
+		var body bytes.Buffer
+
+		gz := gzip.NewWriter(&body)
+		gz.Write(feed.feed)
+		gz.Close()
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(body.Bytes())
+		}))
+
+		for _, transport := range transports {
+			t.Run(feed.name+" "+transport.name, func(t *testing.T) {
+				d := New(
+					WithDataURL(ts.URL+"/data/{apiKey}/online-valid.json.bz2"),
+					WithCredentials("test", "key"),
+					WithHTTPClient(&http.Client{Transport: transport.transport}),
+				)
+
+				err := d.Load(context.Background())
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if found := d.Search([]string{feed.url}); len(found) != 1 {
+					t.Errorf("Search(%q) = %q, want a match", feed.url, found)
+				}
+			})
+		}
+
+		ts.Close()
+	}
+}
+
+func TestLoadDuringLoadQueuesAnother(t *testing.T) {
+	var requests int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	// The first request is held until released, and answered with a feed
+	// that the ones after it have an extra URL on top of.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			close(started)
+			<-release
+			w.Write([]byte(`[{"phish_id": 1, "url": "http://evil.example/login"}]`))
+			return
+		}
+
+		w.Write([]byte(`[{"phish_id": 1, "url": "http://evil.example/login"}, {"phish_id": 2, "url": "http://evil.example/kit"}]`))
+	}))
+	defer ts.Close()
+
+	d := New(WithDataURL(ts.URL+"/{apiKey}"), WithCredentials("test", "key"))
+
+	first := make(chan error)
+	second := make(chan error)
+
+	go func() { first <- d.Load(context.Background()) }()
+	<-started
+	go func() { second <- d.Load(context.Background()) }()
+
+	for queued := false; !queued; time.Sleep(time.Millisecond) {
+		d.loadMutex.Lock()
+		queued = d.queued != nil
+		d.loadMutex.Unlock()
+	}
+
+	close(release)
+
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-second; err != nil {
+		t.Fatal(err)
+	}
+
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("%d requests, want 2", n)
+	}
+
+	if found := d.Search([]string{"http://evil.example/kit"}); len(found) != 1 {
+		t.Error("load queued during another didn't fetch the feed again")
+	}
+}
+
+func TestBloomEntryCountDistinct(t *testing.T) {
+	feed := `[{"phish_id": 1, "url": "http://evil.example/x?1"}, {"phish_id": 2, "url": "http://evil.example/x?2"}, {"phish_id": 3, "url": "http://evil.example/y"}]`
+
+	for _, bloom := range []bool{false, true} {
+		opts := []Option{WithIgnoreQuery(true)}
+
+		if bloom {
+			opts = append(opts, WithBloomFilter(0.0001))
+		}
+
+		d := New(opts...)
+
+		_, err := d.read(strings.NewReader(feed), validators{}, time.Now())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n := d.Stats().EntryCount; n != 2 {
+			t.Errorf("bloom %v: EntryCount = %d, want 2", bloom, n)
+		}
+	}
+}
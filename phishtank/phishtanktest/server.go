@@ -0,0 +1,158 @@
+// Package phishtanktest provides a fake Phishtank feed server for testing code
+// that loads and refreshes a phishtank.DB.
+package phishtanktest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jhammer/phishtankcheck/phishtank"
+)
+
+// Server is a feed server listening on a local address. It serves its feed
+// with an ETag and Last-Modified date, answering conditional GETs for an
+// unchanged feed with 304 Not Modified, and can be made to fail.
+//
+// The feed is sent gzip-compressed rather than as bzip2 like Phishtank's, as
+// the standard library can't write bzip2. A DB detects either.
+type Server struct {
+	*httptest.Server
+
+	mutex        sync.Mutex
+	feed         []byte
+	eTag         string
+	lastModified time.Time
+	status       int
+	requests     int
+}
+
+// NewServer starts a Server whose feed lists urls. Close it when done.
+func NewServer(urls ...string) *Server {
+	s := &Server{}
+	s.SetURLs(urls...)
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveFeed))
+
+	return s
+}
+
+// DataURL returns the URL to give phishtank.WithDataURL, with {apiKey} in
+// place of the API key.
+func (s *Server) DataURL() string {
+	return s.URL + "/data/{apiKey}/online-valid.json.bz2"
+}
+
+// Options returns the options that make a DB load its feed from s.
+func (s *Server) Options() []phishtank.Option {
+	return []phishtank.Option{
+		phishtank.WithDataURL(s.DataURL()),
+		phishtank.WithHTTPClient(s.Client()),
+	}
+}
+
+// SetURLs replaces the feed with one listing urls, numbered from 1, with no
+// target.
+func (s *Server) SetURLs(urls ...string) {
+	phishes := make([]phishtank.Phish, len(urls))
+
+	for i, url := range urls {
+		phishes[i] = phishtank.Phish{
+			PhishID:  json.Number(strconv.Itoa(i + 1)),
+			URL:      url,
+			Verified: "yes",
+			Online:   "yes",
+		}
+	}
+
+	s.SetPhishes(phishes...)
+}
+
+// SetPhishes replaces the feed with one of the given records. A feed that
+// differs from the last gets a new ETag and Last-Modified date.
+func (s *Server) SetPhishes(phishes ...phishtank.Phish) {
+	if phishes == nil {
+		phishes = make([]phishtank.Phish, 0)
+	}
+
+	data, err := json.Marshal(phishes)
+
+	if err != nil {
+		panic(err)
+	}
+
+	var feed bytes.Buffer
+
+	gz := gzip.NewWriter(&feed)
+	gz.Write(data)
+	gz.Close()
+
+	s.setFeed(feed.Bytes(), data)
+}
+
+// SetFeed replaces the feed with raw, served exactly as given, such as a
+// bzip2-compressed feed checked in under testdata. A feed that differs from
+// the last gets a new ETag and Last-Modified date.
+func (s *Server) SetFeed(raw []byte) {
+	s.setFeed(raw, raw)
+}
+
+// setFeed serves feed, with an ETag derived from data.
+func (s *Server) setFeed(feed []byte, data []byte) {
+	eTag := fmt.Sprintf(`"%x"`, sha256.Sum256(data))
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if eTag != s.eTag {
+		s.eTag = eTag
+		s.lastModified = time.Now().UTC().Truncate(time.Second)
+	}
+
+	s.feed = feed
+}
+
+// Fail makes s answer every request with status, such as 500 or 304, until
+// called again with 0.
+func (s *Server) Fail(status int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.status = status
+}
+
+// Requests returns how many requests s has answered, of any kind.
+func (s *Server) Requests() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.requests
+}
+
+func (s *Server) serveFeed(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.requests++
+
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// ServeContent handles HEAD and the conditional headers.
+	w.Header().Set("ETag", s.eTag)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", s.lastModified, bytes.NewReader(s.feed))
+}
@@ -0,0 +1,58 @@
+package phishtanktest
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jhammer/phishtankcheck/phishtank"
+)
+
+func TestSetFeed(t *testing.T) {
+	feed, err := os.ReadFile("testdata/online-valid.json.bz2")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer()
+	defer s.Close()
+
+	s.SetFeed(feed)
+
+	db := phishtank.New(append(s.Options(), phishtank.WithCredentials("test", "key"))...)
+
+	err = db.Load(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := db.Stats().EntryCount; n != 3 {
+		t.Errorf("EntryCount = %d, want 3", n)
+	}
+
+	found := db.SearchDetails([]string{"https://www.bank.example/secure/update.php", "https://www.bank.example/"})
+
+	if len(found) != 1 || found[0].PhishID != "8412002" || found[0].Target != "Other" {
+		t.Errorf("SearchDetails = %+v, want phish 8412002", found)
+	}
+
+	// The same feed again is answered 304 and leaves the database as it was.
+	version := db.Version()
+	s.SetFeed(feed)
+
+	err = db.Load(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Requests() != 2 {
+		t.Errorf("Requests = %d, want 2", s.Requests())
+	}
+
+	if db.Version() != version {
+		t.Errorf("Version changed from %s to %s on an unchanged feed", version, db.Version())
+	}
+}
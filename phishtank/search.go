@@ -0,0 +1,340 @@
+package phishtank
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// lookup calls match for each of urls present in the database, in order, along
+// with the key it was found under and its feed record. A database held in a
+// Bloom filter has no feed records, so match is given a record of just the
+// URL. The URLs are looked up in turn: a lookup is a map read, and splitting
+// a large search across goroutines measured slower than that (see
+// BenchmarkSearch).
+func (d *DB) lookup(urls []string, match func(url string, key string, p Phish)) {
+	atomic.AddInt64(&d.searchCount, 1)
+	atomic.AddInt64(&d.searchURLCount, int64(len(urls)))
+
+	snap := d.current.Load()
+
+	if snap == nil {
+		return
+	}
+
+	var hits int64
+
+	targets := make(map[string]int64)
+
+	for _, url := range urls {
+		if p, key, present := d.find(snap, url); present {
+			hits++
+			targets[p.Target]++
+			match(url, key, p)
+		}
+	}
+
+	atomic.AddInt64(&d.hitURLCount, hits)
+	d.countTargets(targets)
+}
+
+// countTargets adds the hits per target of one search to the running totals.
+// Matches with no known target, from a Bloom filter or a list, aren't counted.
+func (d *DB) countTargets(targets map[string]int64) {
+	delete(targets, "")
+
+	if len(targets) == 0 {
+		return
+	}
+
+	d.targetHitsMutex.Lock()
+	defer d.targetHitsMutex.Unlock()
+
+	if d.targetHits == nil {
+		d.targetHits = make(map[string]int64)
+	}
+
+	for target, hits := range targets {
+		d.targetHits[target] += hits
+	}
+}
+
+// TargetHits is the number of searched URLs found phishing a target.
+type TargetHits struct {
+	Target string `json:"target"`
+	Hits   int64  `json:"hits"`
+}
+
+// TargetHits returns how many searched URLs have been found phishing each
+// target, most hit first.
+func (d *DB) TargetHits() []TargetHits {
+	d.targetHitsMutex.Lock()
+
+	breakdown := make([]TargetHits, 0, len(d.targetHits))
+
+	for target, hits := range d.targetHits {
+		breakdown = append(breakdown, TargetHits{Target: target, Hits: hits})
+	}
+
+	d.targetHitsMutex.Unlock()
+
+	sort.Slice(breakdown, func(i, j int) bool {
+		if breakdown[i].Hits != breakdown[j].Hits {
+			return breakdown[i].Hits > breakdown[j].Hits
+		}
+
+		return breakdown[i].Target < breakdown[j].Target
+	})
+
+	return breakdown
+}
+
+// find looks up url in snap, returning the key it was found under. If it
+// isn't there and the DB was created WithPathPrefix, a URL with the same host
+// is looked for whose path is a prefix of url's.
+func (d *DB) find(snap *snapshot, url string) (Phish, string, bool) {
+	key := d.normalizer.normalize(url)
+	p, present := snap.get(key, url)
+
+	if present || !d.matchPathPrefix {
+		return p, key, present
+	}
+
+	if _, allowed := snap.allowed.get(key); allowed {
+		return Phish{}, key, false
+	}
+
+	if p, prefix, present := snap.getPrefix(key, url); present {
+		return p, prefix, true
+	}
+
+	return Phish{}, key, false
+}
+
+// Search returns those of urls present in the database, in order.
+func (d *DB) Search(urls []string) []string {
+	found := make([]string, 0)
+
+	d.lookup(urls, func(url string, _ string, _ Phish) {
+		found = append(found, url)
+	})
+
+	return found
+}
+
+// SearchDetails is like Search but returns the feed records of the matches.
+func (d *DB) SearchDetails(urls []string) []Phish {
+	found := make([]Phish, 0)
+
+	d.lookup(urls, func(_ string, _ string, p Phish) {
+		found = append(found, p)
+	})
+
+	return found
+}
+
+// Verdicts records whether each of a list of URLs was found. It marshals to a
+// JSON object of URL to boolean, in the order the URLs were listed.
+type Verdicts struct {
+	urls  []string
+	found map[string]bool
+}
+
+// Found reports whether url was found.
+func (v Verdicts) Found(url string) bool {
+	return v.found[url]
+}
+
+// URLs returns the URLs searched for, without duplicates, in order.
+func (v Verdicts) URLs() []string {
+	return v.urls
+}
+
+func (v Verdicts) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+
+	b.WriteByte('{')
+
+	for i, url := range v.urls {
+		key, err := json.Marshal(url)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		b.Write(key)
+		b.WriteByte(':')
+		b.WriteString(strconv.FormatBool(v.found[url]))
+	}
+
+	b.WriteByte('}')
+
+	return b.Bytes(), nil
+}
+
+// SearchVerdicts is like Search but reports on every one of urls, listing
+// duplicates once.
+func (d *DB) SearchVerdicts(urls []string) Verdicts {
+	v := Verdicts{
+		urls:  make([]string, 0, len(urls)),
+		found: make(map[string]bool, len(urls)),
+	}
+
+	for _, url := range urls {
+		if _, seen := v.found[url]; !seen {
+			v.urls = append(v.urls, url)
+			v.found[url] = false
+		}
+	}
+
+	d.lookup(v.urls, func(url string, _ string, _ Phish) {
+		v.found[url] = true
+	})
+
+	return v
+}
+
+// Explanation is the verdict on a searched URL along with the normalized key
+// it was looked up by, which is what must match for it to be found. A URL
+// found under a path prefix, with WithPathPrefix, reports the prefix's key.
+type Explanation struct {
+	URL   string `json:"url"`
+	Key   string `json:"key"`
+	Found bool   `json:"found"`
+}
+
+// SearchExplained is like SearchVerdicts but also reports the key each URL
+// was looked up by, to show why it did or didn't match.
+func (d *DB) SearchExplained(urls []string) []Explanation {
+	explained := make([]Explanation, 0, len(urls))
+	index := make(map[string]int, len(urls))
+
+	for _, url := range urls {
+		if _, seen := index[url]; !seen {
+			index[url] = len(explained)
+			explained = append(explained, Explanation{URL: url, Key: d.normalizer.normalize(url)})
+		}
+	}
+
+	unique := make([]string, len(explained))
+
+	for i, e := range explained {
+		unique[i] = e.URL
+	}
+
+	d.lookup(unique, func(url string, key string, _ Phish) {
+		explained[index[url]].Key = key
+		explained[index[url]].Found = true
+	})
+
+	return explained
+}
+
+// SearchDomains returns those of urls whose hostname appears anywhere in the
+// database, or if the DB was created WithMatchSubdomains, whose hostname or
+// any parent domain of it does.
+func (d *DB) SearchDomains(urls []string) []string {
+	atomic.AddInt64(&d.searchCount, 1)
+	atomic.AddInt64(&d.searchURLCount, int64(len(urls)))
+
+	found := make([]string, 0)
+	snap := d.current.Load()
+
+	if snap == nil {
+		return found
+	}
+
+	hasHost := snap.hasHost
+
+	if d.matchSubdomains {
+		hasHost = snap.hasHostOrParent
+	}
+
+	for _, url := range urls {
+		if hasHost(d.normalizer.hostname(url)) {
+			found = append(found, url)
+		}
+	}
+
+	atomic.AddInt64(&d.hitURLCount, int64(len(found)))
+
+	return found
+}
+
+// URLsByHost returns the URLs in the feed whose hostname is that of host,
+// which may be a bare hostname or a URL, leaving out any allowlisted. It
+// returns nil unless the DB was created WithIndex.
+func (d *DB) URLsByHost(host string) []string {
+	snap := d.current.Load()
+
+	if snap == nil || snap.byHost == nil {
+		return nil
+	}
+
+	return d.allowedURLs(snap, snap.byHost[d.normalizer.hostname(host)])
+}
+
+// URLsByTarget returns the URLs in the feed phishing the given target, such
+// as "PayPal", ignoring case and leaving out any allowlisted. It returns nil
+// unless the DB was created WithIndex.
+func (d *DB) URLsByTarget(target string) []string {
+	snap := d.current.Load()
+
+	if snap == nil || snap.byTarget == nil {
+		return nil
+	}
+
+	return d.allowedURLs(snap, snap.byTarget[strings.ToLower(target)])
+}
+
+// allowedURLs returns a copy of urls without any on snap's allowlist.
+func (d *DB) allowedURLs(snap *snapshot, urls []string) []string {
+	found := make([]string, 0, len(urls))
+
+	for _, url := range urls {
+		if _, allowed := snap.allowed.get(d.normalizer.normalize(url)); !allowed {
+			found = append(found, url)
+		}
+	}
+
+	return found
+}
+
+// URLs returns every URL in the database, from the feed, the extra list and
+// the OpenPhish feed, sorted and leaving out any allowlisted. A URL listed in
+// several places is returned once. It returns nil for a DB held in a Bloom
+// filter, which can't list its URLs.
+func (d *DB) URLs() []string {
+	snap := d.current.Load()
+
+	if snap == nil || snap.filter != nil {
+		return nil
+	}
+
+	seen := make(map[string]struct{}, snap.count+snap.listCount)
+	urls := make([]string, 0, snap.count+snap.listCount)
+
+	for _, source := range []map[string]Phish{snap.urls, snap.extra.all(), snap.openPhish.all()} {
+		for key, p := range source {
+			if _, allowed := snap.allowed.get(key); allowed {
+				continue
+			}
+
+			if _, dup := seen[key]; !dup {
+				seen[key] = struct{}{}
+				urls = append(urls, p.URL)
+			}
+		}
+	}
+
+	sort.Strings(urls)
+
+	return urls
+}
@@ -0,0 +1,153 @@
+package phishtank
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// benchmarkFeed returns a JSON feed of n URLs, and n searched URLs of which
+// every other one is in it.
+func benchmarkFeed(n int) ([]byte, []string) {
+	phishes := make([]Phish, n)
+	urls := make([]string, n)
+
+	for i := range phishes {
+		phishes[i] = Phish{
+			PhishID: json.Number(strconv.Itoa(i + 1)),
+			URL:     fmt.Sprintf("http://host%d.example/login/%d", i%1000, i),
+			Target:  "Other",
+		}
+
+		if i%2 == 0 {
+			urls[i] = phishes[i].URL
+		} else {
+			urls[i] = fmt.Sprintf("http://host%d.example/safe/%d", i%1000, i)
+		}
+	}
+
+	feed, err := json.Marshal(phishes)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return feed, urls
+}
+
+// benchmarkDB returns a DB loaded with feed.
+func benchmarkDB(b *testing.B, feed []byte) *DB {
+	b.Helper()
+
+	d := New()
+
+	_, err := d.read(bytes.NewReader(feed), validators{}, time.Now())
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return d
+}
+
+func TestSearchExplainedPathPrefix(t *testing.T) {
+	d := New(WithPathPrefix(true))
+
+	_, err := d.read(strings.NewReader(`[{"phish_id": 1, "url": "http://evil.example/kit/"}, {"phish_id": 2, "url": "http://bare.example"}, {"phish_id": 3, "url": "http://slash.example/"}]`), validators{}, time.Now())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := d.SearchExplained([]string{
+		"http://evil.example/kit/step2?id=1",
+		"http://evil.example/Kit/",
+		"http://good.example/kit/step2",
+		"http://evil.example/kit/step2?id=1",
+		"http://bare.example/kit",
+		"http://bare.example/?a",
+		"http://bare.example?a",
+		"http://slash.example?a",
+		"http://slash.example/kit/step2",
+	})
+	want := []Explanation{
+		{URL: "http://evil.example/kit/step2?id=1", Key: "http://evil.example/kit", Found: true},
+		{URL: "http://evil.example/Kit/", Key: "http://evil.example/kit", Found: true},
+		{URL: "http://good.example/kit/step2", Key: "http://good.example/kit/step2", Found: false},
+		{URL: "http://bare.example/kit", Key: "http://bare.example", Found: true},
+		{URL: "http://bare.example/?a", Key: "http://bare.example", Found: true},
+		{URL: "http://bare.example?a", Key: "http://bare.example", Found: true},
+		{URL: "http://slash.example?a", Key: "http://slash.example/", Found: true},
+		{URL: "http://slash.example/kit/step2", Key: "http://slash.example/", Found: true},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("SearchExplained = %+v, want %+v", got, want)
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("SearchExplained[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func BenchmarkSearch(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000, 100000} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			feed, urls := benchmarkFeed(n)
+			d := benchmarkDB(b, feed)
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				d.Search(urls)
+			}
+		})
+	}
+}
+
+// BenchmarkSearchDuringLoad searches while the feed is reloaded over and over,
+// which searches shouldn't wait for. Compare it with BenchmarkSearch/100.
+func BenchmarkSearchDuringLoad(b *testing.B) {
+	feed, urls := benchmarkFeed(100000)
+	d := benchmarkDB(b, feed)
+	urls = urls[:100]
+
+	done := make(chan struct{})
+	loaded := make(chan struct{})
+
+	go func() {
+		defer close(loaded)
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			_, err := d.read(bytes.NewReader(feed), validators{}, time.Now())
+
+			if err != nil {
+				panic(err)
+			}
+		}
+	}()
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			d.Search(urls)
+		}
+	})
+
+	b.StopTimer()
+	close(done)
+	<-loaded
+}
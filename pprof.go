@@ -0,0 +1,22 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/pprof"
+)
+
+// servePprof serves the runtime profiling endpoints under /debug/pprof/ on
+// addr. They have a listener of their own, separate from the service's, so
+// that they can be bound to localhost and are never exposed by accident.
+func servePprof(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	log.Print("Serving pprof on " + addr)
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimiter limits the rate of requests from each client IP with a token
+// bucket per client.
+type rateLimiter struct {
+	limit      rate.Limit
+	burst      int
+	trustProxy bool
+	mutex      sync.Mutex
+	clients    map[string]*limitedClient
+}
+
+type limitedClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newRateLimiter(limit float64, burst int, trustProxy bool) *rateLimiter {
+	return &rateLimiter{
+		limit:      rate.Limit(limit),
+		burst:      burst,
+		trustProxy: trustProxy,
+		clients:    make(map[string]*limitedClient),
+	}
+}
+
+// reserve takes a token from ip's bucket, returning how long it must wait if
+// there isn't one available.
+func (l *rateLimiter) reserve(ip string) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	c, present := l.clients[ip]
+
+	if !present {
+		c = &limitedClient{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[ip] = c
+	}
+
+	c.lastSeen = time.Now()
+	r := c.limiter.Reserve()
+
+	if !r.OK() {
+		return time.Second
+	}
+
+	delay := r.Delay()
+
+	if delay > 0 {
+		r.Cancel()
+	}
+
+	return delay
+}
+
+// prune forgets clients that haven't made a request within maxIdle.
+func (l *rateLimiter) prune(maxIdle time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for ip, c := range l.clients {
+		if time.Since(c.lastSeen) > maxIdle {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+// run prunes idle clients every interval until done is closed.
+func (l *rateLimiter) run(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			l.prune(interval)
+		}
+	}
+}
+
+// limitHandler rejects requests to next from clients over their rate with 429.
+func (l *rateLimiter) limitHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		delay := l.reserve(clientIP(r, l.trustProxy))
+
+		if delay > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"github.com/jhammer/phishtankcheck/phishtank"
+)
+
+// minRefreshHint is the shortest refresh interval taken from the feed's cache
+// headers, so that a short max-age can't have us hammering the feed.
+const minRefreshHint = 5 * time.Minute
+
+// refresher periodically reloads a database, retrying with exponential backoff
+// after a failed load. Its loads are cancelled, and it stops, when ctx is done.
+type refresher struct {
+	ctx           context.Context
+	db            *phishtank.DB
+	logger        *slog.Logger
+	interval      time.Duration
+	useHint       bool
+	jitter        float64
+	retryDelay    time.Duration
+	maxRetryDelay time.Duration
+}
+
+// initialLoad loads db at startup, retrying up to retries times, delay apart,
+// if it fails. Each failed attempt but the last is logged, and the last
+// error is returned. It gives up as soon as ctx is done.
+func initialLoad(ctx context.Context, db *phishtank.DB, logger *slog.Logger, retries int, delay time.Duration) error {
+	for attempt := 1; ; attempt++ {
+		err := tracedLoad(ctx, db, "startup")
+
+		if err == nil || attempt > retries || ctx.Err() != nil {
+			return err
+		}
+
+		logger.Warn("Error loading database, retrying", "attempt", attempt, "attempts", retries+1, "error", err, "retryIn", delay.String())
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// run refreshes the database until ctx is done. If loaded is false the first
+// refresh is treated as a retry rather than waiting a full interval.
+func (r *refresher) run(loaded bool) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	retryDelay := r.retryDelay
+	delay := r.nextInterval(rng)
+
+	if !loaded {
+		delay = retryDelay
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		err := tracedLoad(r.ctx, r.db, "schedule")
+
+		if r.ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			r.logger.Error("Error refreshing database", "error", err, "retryIn", retryDelay.String())
+			delay = retryDelay
+			retryDelay *= 2
+
+			if retryDelay > r.maxRetryDelay {
+				retryDelay = r.maxRetryDelay
+			}
+		} else {
+			c := r.db.LastChange()
+			r.logger.Info("Refreshed database", "entries", c.EntryCount, "added", c.Added, "removed", c.Removed, "suppressed", c.Suppressed)
+			delay = r.nextInterval(rng)
+			retryDelay = r.retryDelay
+		}
+
+		timer.Reset(delay)
+	}
+}
+
+// nextInterval returns the refresh interval lengthened by a random fraction of
+// up to jitter, so that instances started together spread out their fetches.
+// If useHint is set and the feed said how long it stays fresh, that is used
+// in place of the refresh interval.
+func (r *refresher) nextInterval(rng *rand.Rand) time.Duration {
+	interval := r.interval
+
+	if hint := r.db.RefreshHint(); r.useHint && hint > 0 {
+		interval = max(hint, minRefreshHint)
+	}
+
+	if r.jitter <= 0 {
+		return interval
+	}
+
+	return interval + time.Duration(rng.Float64()*r.jitter*float64(interval))
+}
+
+// reload loads the database immediately at the request of source, logging the
+// outcome. It doesn't affect the refresh schedule.
+func (r *refresher) reload(source string) {
+	err := tracedLoad(r.ctx, r.db, source)
+
+	if r.ctx.Err() != nil {
+		return
+	}
+
+	if err != nil {
+		r.logger.Error("Error reloading database", "source", source, "error", err)
+	} else {
+		c := r.db.LastChange()
+		r.logger.Info("Reloaded database", "source", source, "entries", c.EntryCount, "added", c.Added, "removed", c.Removed, "suppressed", c.Suppressed)
+	}
+}
+
+// refreshOpenPhish reloads the OpenPhish feed into db every interval until ctx
+// is done, independently of the Phishtank refreshes.
+func refreshOpenPhish(ctx context.Context, db *phishtank.DB, logger *slog.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := db.LoadOpenPhish(ctx)
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			logger.Error("Error refreshing OpenPhish feed", "error", err)
+		} else {
+			logger.Info("Refreshed OpenPhish feed", "entries", db.Stats().OpenPhishCount)
+		}
+	}
+}
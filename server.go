@@ -0,0 +1,620 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jhammer/phishtankcheck/phishtank"
+)
+
+// server serves searches of a database over HTTP.
+type server struct {
+	db           *phishtank.DB
+	refresher    *refresher
+	startTime    time.Time
+	maxStaleness time.Duration
+	maxBodyBytes int64
+	maxURLs      int
+	authToken    string
+	validateURLs bool
+	indexed      bool
+	tracing      bool
+	limiter      *rateLimiter
+	latency      latencyHistogram
+}
+
+// routes registers the server's endpoints on mux.
+func (s *server) routes(mux *http.ServeMux) {
+	handle := func(pattern string, handler http.HandlerFunc) {
+		if s.tracing {
+			handler = traced(pattern, handler)
+		}
+
+		mux.HandleFunc(pattern, handler)
+	}
+
+	handle("/search", s.limit(s.auth(s.ready(s.latency.time(compress(s.handleSearch))))))
+	handle("/search/domain", s.limit(s.auth(s.ready(s.latency.time(compress(s.handleSearchDomain))))))
+	handle("/status", s.auth(compress(s.handleStatus)))
+	handle("/stats", s.auth(compress(s.handleStats)))
+
+	if s.indexed {
+		handle("/by-domain", s.limit(s.auth(s.ready(compress(s.handleByDomain)))))
+		handle("/by-target", s.limit(s.auth(s.ready(compress(s.handleByTarget)))))
+	}
+
+	handle("/diff", s.auth(s.handleDiff))
+	handle("/dump", s.auth(s.ready(compress(s.handleDump))))
+	handle("/reload", s.auth(s.handleReload))
+	handle("/version", s.handleVersion)
+	handle("/healthz", s.handleHealthz)
+	handle("/readyz", s.handleReadyz)
+	handle("/metrics", s.auth(metricsHandler(s.db, &s.latency)))
+}
+
+func (s *server) auth(next http.HandlerFunc) http.HandlerFunc {
+	return requireToken(s.authToken, next)
+}
+
+func (s *server) ready(next http.HandlerFunc) http.HandlerFunc {
+	return requireReady(s.db, next)
+}
+
+func (s *server) limit(next http.HandlerFunc) http.HandlerFunc {
+	if s.limiter == nil {
+		return next
+	}
+
+	return s.limiter.limitHandler(next)
+}
+
+// stale reports whether the database has gone longer than maxStaleness without
+// a successful refresh. A zero maxStaleness disables the check.
+func (s *server) stale() bool {
+	return s.maxStaleness > 0 && s.db.Age() > s.maxStaleness
+}
+
+// setDataHeaders describes the data a search is answered from in the headers
+// of w: its version as a weak ETag, when it was last updated, and whether it's
+// stale. Clients can compare the ETag to tell whether results may have changed.
+func (s *server) setDataHeaders(w http.ResponseWriter) {
+	if version := s.db.Version(); version != "" {
+		w.Header().Set("ETag", `W/"`+version+`"`)
+	}
+
+	if updated := s.db.Updated(); !updated.IsZero() {
+		w.Header().Set("X-Data-Updated", updated.UTC().Format(time.RFC3339))
+	}
+
+	if s.stale() {
+		w.Header().Set("X-Data-Stale", "true")
+	}
+}
+
+func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.setDataHeaders(w)
+
+	var req searchRequest
+
+	// A GET for a single URL reports on just that one. One for several, with
+	// the url parameter repeated, is answered like a POST of them.
+	if r.Method == http.MethodGet {
+		req.URLs = r.URL.Query()["url"]
+
+		if len(req.URLs) == 0 || req.URLs[0] == "" {
+			http.Error(w, "url parameter required", http.StatusBadRequest)
+			return
+		}
+
+		if s.maxURLs > 0 && len(req.URLs) > s.maxURLs {
+			http.Error(w, fmt.Sprintf("Too many URLs: at most %d allowed per request", s.maxURLs), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if len(req.URLs) == 1 {
+			url := req.URLs[0]
+
+			if !s.validate(w, []string{url}, queryBool(r, "validate")) {
+				return
+			}
+
+			result := struct {
+				URL   string `json:"url"`
+				Phish bool   `json:"phish"`
+			}{
+				URL:   url,
+				Phish: len(s.db.Search([]string{url})) > 0,
+			}
+
+			matches := 0
+
+			if result.Phish {
+				matches = 1
+			}
+
+			traceSearch(r, 1, matches)
+
+			if !result.Phish && queryBool(r, "statusOnly") {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(result)
+			return
+		}
+	} else {
+		var ok bool
+
+		req, ok = decodeSearch(w, r, s.maxBodyBytes, s.maxURLs)
+
+		if !ok {
+			return
+		}
+	}
+
+	urls := req.URLs
+
+	if !s.validate(w, urls, req.Validate || queryBool(r, "validate")) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept")
+
+	if req.Details || queryBool(r, "details") {
+		found := s.db.SearchDetails(urls)
+		traceSearch(r, len(urls), len(found))
+		json.NewEncoder(w).Encode(found)
+		return
+	}
+
+	if req.Explain || queryBool(r, "explain") {
+		explained := s.db.SearchExplained(urls)
+		matches := 0
+
+		for _, e := range explained {
+			if e.Found {
+				matches++
+			}
+		}
+
+		traceSearch(r, len(urls), matches)
+		json.NewEncoder(w).Encode(explained)
+		return
+	}
+
+	if req.Verbose || queryBool(r, "verbose") {
+		verdicts := s.db.SearchVerdicts(urls)
+		matches := 0
+
+		for _, url := range verdicts.URLs() {
+			if verdicts.Found(url) {
+				matches++
+			}
+		}
+
+		traceSearch(r, len(urls), matches)
+		json.NewEncoder(w).Encode(verdicts)
+		return
+	}
+
+	found := arrange(s.db.Search(urls), req.Unique || queryBool(r, "unique"), req.Sort || queryBool(r, "sort"))
+	traceSearch(r, len(urls), len(found))
+
+	// A client that only wants to know whether anything matched can tell from
+	// the status.
+	if len(found) == 0 && (req.StatusOnly || queryBool(r, "statusOnly")) {
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if wantsPlainText(r) {
+		writeLines(w, found)
+		return
+	}
+
+	json.NewEncoder(w).Encode(found)
+}
+
+// validate rejects the search of urls with 400, listing those that aren't
+// valid absolute URLs, if validation is enabled by -validateURLs or requested.
+// It returns false if it wrote an error response.
+func (s *server) validate(w http.ResponseWriter, urls []string, requested bool) bool {
+	if !s.validateURLs && !requested {
+		return true
+	}
+
+	var invalid []string
+
+	for _, url := range urls {
+		if !phishtank.ValidURL(url) {
+			invalid = append(invalid, url)
+		}
+	}
+
+	if len(invalid) == 0 {
+		return true
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprintln(w, "Invalid URLs:")
+
+	for _, url := range invalid {
+		fmt.Fprintln(w, url)
+	}
+
+	return false
+}
+
+func (s *server) handleSearchDomain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.setDataHeaders(w)
+
+	req, ok := decodeSearch(w, r, s.maxBodyBytes, s.maxURLs)
+
+	if !ok {
+		return
+	}
+
+	found := arrange(s.db.SearchDomains(req.URLs), req.Unique || queryBool(r, "unique"), req.Sort || queryBool(r, "sort"))
+	traceSearch(r, len(req.URLs), len(found))
+
+	w.Header().Add("Vary", "Accept")
+
+	if wantsPlainText(r) {
+		writeLines(w, found)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(found)
+}
+
+func (s *server) handleByDomain(w http.ResponseWriter, r *http.Request) {
+	host := r.URL.Query().Get("host")
+
+	if host == "" {
+		http.Error(w, "host parameter required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.db.URLsByHost(host))
+}
+
+func (s *server) handleByTarget(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+
+	if target == "" {
+		http.Error(w, "target parameter required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.db.URLsByTarget(target))
+}
+
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := struct {
+		Uptime string
+		phishtank.Stats
+		Stale         bool
+		SearchLatency latencySummary
+		Runtime       *runtimeStats `json:",omitempty"`
+	}{
+		Uptime:        time.Since(s.startTime).String(),
+		Stats:         s.db.Stats(),
+		Stale:         s.stale(),
+		SearchLatency: s.latency.summary(),
+	}
+
+	if queryBool(r, "runtime") {
+		status.Runtime = readRuntimeStats()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func (s *server) handleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.db.TargetHits())
+}
+
+// runtimeStats is a summary of the process's goroutines, memory and garbage
+// collection, reported by /status?runtime=true.
+type runtimeStats struct {
+	Goroutines int
+	HeapAlloc  uint64
+	HeapSys    uint64
+	NumGC      uint32
+}
+
+// readRuntimeStats reads the current runtime stats. This briefly stops the
+// world, so it's only done when asked for.
+func readRuntimeStats() *runtimeStats {
+	var m runtime.MemStats
+
+	runtime.ReadMemStats(&m)
+
+	return &runtimeStats{
+		Goroutines: runtime.NumGoroutine(),
+		HeapAlloc:  m.HeapAlloc,
+		HeapSys:    m.HeapSys,
+		NumGC:      m.NumGC,
+	}
+}
+
+func (s *server) handleDiff(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.db.Diff())
+}
+
+// handleDump lists every URL in the database, sorted, one per line or as a
+// JSON array if that's accepted or ?format=json. It's written as it goes
+// rather than built up in full first, as the list is large.
+func (s *server) handleDump(w http.ResponseWriter, r *http.Request) {
+	urls := s.db.URLs()
+
+	if urls == nil {
+		http.Error(w, "URLs can't be listed from a Bloom filter", http.StatusNotImplemented)
+		return
+	}
+
+	asJSON := r.URL.Query().Get("format") == "json" || accepts(r, "application/json")
+	out := bufio.NewWriter(w)
+
+	if !asJSON {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+		for _, url := range urls {
+			out.WriteString(url)
+			out.WriteByte('\n')
+		}
+
+		out.Flush()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	out.WriteByte('[')
+
+	for i, url := range urls {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+
+		b, _ := json.Marshal(url)
+		out.Write(b)
+	}
+
+	out.WriteString("]\n")
+	out.Flush()
+}
+
+func (s *server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	go s.refresher.reload("/reload")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentBuild())
+}
+
+func (s *server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !s.db.Ready() {
+		http.Error(w, "Database not yet loaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	if s.stale() {
+		http.Error(w, "Database is stale", http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
+
+// searchRequest is the body of a search: the URLs to search for, along with
+// options that may also be given as query parameters.
+type searchRequest struct {
+	URLs       []string `json:"urls"`
+	Details    bool     `json:"details"`
+	Verbose    bool     `json:"verbose"`
+	Explain    bool     `json:"explain"`
+	Validate   bool     `json:"validate"`
+	Unique     bool     `json:"unique"`
+	Sort       bool     `json:"sort"`
+	StatusOnly bool     `json:"statusOnly"`
+}
+
+// decodeSearch decodes the search in the body of r: a JSON array of URLs, a
+// JSON object with the URLs in its urls field or, for text/plain, the URLs one
+// per line. The body may be at most maxBytes long and list at most maxURLs
+// URLs. If it can't, it writes an error response and returns false.
+func decodeSearch(w http.ResponseWriter, r *http.Request, maxBytes int64, maxURLs int) (searchRequest, bool) {
+	var req searchRequest
+	var err error
+
+	body := http.MaxBytesReader(w, r.Body, maxBytes)
+
+	if isPlainText(r) {
+		req.URLs, err = readLines(body)
+	} else {
+		var raw json.RawMessage
+
+		err = json.NewDecoder(body).Decode(&raw)
+
+		if err == nil && bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+			err = json.Unmarshal(raw, &req)
+		} else if err == nil {
+			err = json.Unmarshal(raw, &req.URLs)
+		}
+	}
+
+	var tooLarge *http.MaxBytesError
+
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return req, false
+	}
+
+	if err != nil {
+		http.Error(w, decodeErrorMessage(err), http.StatusBadRequest)
+		return req, false
+	}
+
+	if maxURLs > 0 && len(req.URLs) > maxURLs {
+		http.Error(w, fmt.Sprintf("Too many URLs: at most %d allowed per request", maxURLs), http.StatusRequestEntityTooLarge)
+		return req, false
+	}
+
+	if req.URLs == nil {
+		req.URLs = make([]string, 0)
+	}
+
+	return req, true
+}
+
+// decodeErrorMessage explains why a search body failed to decode with err.
+func decodeErrorMessage(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.Is(err, io.EOF):
+		return "Request body is empty: expected a JSON array of URL strings"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "Invalid JSON: body ends unexpectedly"
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("Invalid JSON at byte offset %d: %v", syntaxErr.Offset, syntaxErr)
+	case errors.As(err, &typeErr) && typeErr.Type.Kind() == reflect.String:
+		return fmt.Sprintf("Expected URL strings, but found a JSON %s at byte offset %d", typeErr.Value, typeErr.Offset)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		expected := "an array of URL strings"
+
+		if typeErr.Type.Kind() == reflect.Bool {
+			expected = "true or false"
+		}
+
+		return fmt.Sprintf("Invalid %s field at byte offset %d: found a JSON %s, expected %s", typeErr.Field, typeErr.Offset, typeErr.Value, expected)
+	case errors.As(err, &typeErr):
+		return fmt.Sprintf("Expected a JSON array of URL strings, or an object with a urls field, but found a JSON %s at byte offset %d", typeErr.Value, typeErr.Offset)
+	}
+
+	return "Error decoding body: " + err.Error()
+}
+
+// arrange returns the matched urls with duplicates removed if unique is set,
+// and sorted if sort is set. Otherwise they're left in the order searched for.
+func arrange(urls []string, unique bool, sorted bool) []string {
+	if unique {
+		seen := make(map[string]bool, len(urls))
+		distinct := make([]string, 0, len(urls))
+
+		for _, url := range urls {
+			if !seen[url] {
+				seen[url] = true
+				distinct = append(distinct, url)
+			}
+		}
+
+		urls = distinct
+	}
+
+	if sorted {
+		sort.Strings(urls)
+	}
+
+	return urls
+}
+
+// isPlainText reports whether the body of r is plain text.
+func isPlainText(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "text/plain"
+}
+
+// wantsPlainText reports whether the matches found by r should be listed as
+// plain text rather than JSON: if it accepts text/plain, or sent its URLs as
+// plain text, and doesn't also accept application/json.
+func wantsPlainText(r *http.Request) bool {
+	return (accepts(r, "text/plain") || isPlainText(r)) && !accepts(r, "application/json")
+}
+
+// accepts reports whether the Accept header of r explicitly lists mediaType.
+func accepts(r *http.Request, mediaType string) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		accepted, _, err := mime.ParseMediaType(accept)
+
+		if err == nil && accepted == mediaType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readLines reads the non-blank lines of r, trimmed of surrounding space.
+func readLines(r io.Reader) ([]string, error) {
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+// writeLines writes lines as a plain text response, one per line.
+func writeLines(w http.ResponseWriter, lines []string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// queryBool reports whether the query parameter name is set to a true value.
+func queryBool(r *http.Request, name string) bool {
+	value, err := strconv.ParseBool(r.URL.Query().Get(name))
+	return err == nil && value
+}
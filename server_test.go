@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jhammer/phishtankcheck/phishtank"
+	"github.com/jhammer/phishtankcheck/phishtank/phishtanktest"
+)
+
+// newTestServer returns a server answering from a DB loaded from a feed
+// listing urls, and an HTTP server serving its routes. Close both when done.
+func newTestServer(t *testing.T, s *server, urls ...string) (*phishtanktest.Server, *httptest.Server) {
+	t.Helper()
+
+	feed := phishtanktest.NewServer(urls...)
+	s.db = phishtank.New(append(feed.Options(), phishtank.WithCredentials("test", "key"))...)
+
+	err := s.db.Load(context.Background())
+
+	if err != nil {
+		feed.Close()
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	s.routes(mux)
+
+	return feed, httptest.NewServer(mux)
+}
+
+func TestSearchMaxBodyBytes(t *testing.T) {
+	feed, ts := newTestServer(t, &server{maxBodyBytes: 256}, "http://evil.example/login")
+	defer feed.Close()
+	defer ts.Close()
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"under the limit", `["http://evil.example/login", "http://good.example/"]`, http.StatusOK},
+		{"at the limit", `["http://evil.example/login", "http://good.example/` + strings.Repeat("a", 256-53) + `"]`, http.StatusOK},
+		{"over the limit", `["http://evil.example/login", "http://good.example/` + strings.Repeat("a", 256) + `"]`, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := http.Post(ts.URL+"/search", "application/json", strings.NewReader(test.body))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer res.Body.Close()
+
+			if res.StatusCode != test.status {
+				t.Fatalf("status = %d, want %d", res.StatusCode, test.status)
+			}
+
+			if test.status != http.StatusOK {
+				return
+			}
+
+			var found []string
+
+			err = json.NewDecoder(res.Body).Decode(&found)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(found) != 1 || found[0] != "http://evil.example/login" {
+				t.Errorf("found %q, want [http://evil.example/login]", found)
+			}
+		})
+	}
+}
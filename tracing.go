@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/jhammer/phishtankcheck/phishtank"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the spans created here. Until setupTracing is called
+// the global tracer provider is a no-op, so creating them costs next to
+// nothing.
+const tracerName = "github.com/jhammer/phishtankcheck"
+
+// setupTracing exports spans to the OTLP/HTTP collector at endpoint, such as
+// http://localhost:4318, and continues traces from incoming W3C traceparent
+// headers. It returns a function that flushes the spans and stops exporting.
+func setupTracing(endpoint string) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName("phishtankcheck"),
+			semconv.ServiceVersion(currentBuild().Version))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider.Shutdown, nil
+}
+
+// traced answers each request to next within a server span named for route,
+// as a child of any trace the request carries.
+func traced(route string, next http.HandlerFunc) http.HandlerFunc {
+	tracer := otel.Tracer(tracerName)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(route),
+				attribute.String("phishtank.request_id", requestIDFrom(r))))
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next(recorder, r.WithContext(ctx))
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(recorder.status))
+
+		if recorder.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	}
+}
+
+// traceSearch records the number of URLs searched for by r, and the number
+// found, on its span.
+func traceSearch(r *http.Request, urls int, matches int) {
+	trace.SpanFromContext(r.Context()).SetAttributes(
+		attribute.Int("phishtank.url_count", urls),
+		attribute.Int("phishtank.match_count", matches))
+}
+
+// tracedLoad loads db within a span, recording the reason for the load and
+// its outcome.
+func tracedLoad(ctx context.Context, db *phishtank.DB, reason string) error {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "phishtank.load",
+		trace.WithAttributes(attribute.String("phishtank.load.reason", reason)))
+	defer span.End()
+
+	err := db.Load(ctx)
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	c := db.LastChange()
+	span.SetAttributes(
+		attribute.Int("phishtank.entries", c.EntryCount),
+		attribute.Int("phishtank.added", c.Added),
+		attribute.Int("phishtank.removed", c.Removed))
+
+	return nil
+}
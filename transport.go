@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// transportConfig configures how feeds are fetched.
+type transportConfig struct {
+	// proxy is the URL of an HTTP, HTTPS or SOCKS5 proxy for all fetches. If
+	// it's empty the proxy is taken from the environment as usual.
+	proxy string
+
+	// caFile is a PEM bundle of CA certificates trusted in addition to the
+	// system's, for mirrors with a private CA or TLS-intercepting proxies.
+	caFile string
+
+	// insecureSkipVerify disables verification of the feed server's
+	// certificate, leaving fetches open to interception.
+	insecureSkipVerify bool
+}
+
+// newTransport returns the transport for fetching feeds configured by c. The
+// listening server's TLS is unaffected.
+func newTransport(c transportConfig) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if c.proxy != "" {
+		proxyURL, err := url.Parse(c.proxy)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %v", err)
+		}
+
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q: use http, https or socks5", proxyURL.Scheme)
+		}
+
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if c.caFile != "" || c.insecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: c.insecureSkipVerify}
+	}
+
+	if c.caFile != "" {
+		pem, err := os.ReadFile(c.caFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.caFile)
+		}
+
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	return transport, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import "runtime/debug"
+
+// Set at build time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.date=...".
+var (
+	version string
+	commit  string
+	date    string
+)
+
+type buildInfo struct {
+	Version string
+	Commit  string
+	Date    string
+}
+
+// currentBuild returns the version information of the binary, falling back to
+// what the Go toolchain recorded for anything not set by -ldflags.
+func currentBuild() buildInfo {
+	b := buildInfo{Version: version, Commit: commit, Date: date}
+
+	info, ok := debug.ReadBuildInfo()
+
+	if !ok {
+		return b
+	}
+
+	if b.Version == "" {
+		b.Version = info.Main.Version
+	}
+
+	for _, setting := range info.Settings {
+		switch {
+		case setting.Key == "vcs.revision" && b.Commit == "":
+			b.Commit = setting.Value
+		case setting.Key == "vcs.time" && b.Date == "":
+			b.Date = setting.Value
+		}
+	}
+
+	return b
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchFile calls reload once path has settled after each change to it, that
+// is once delay has passed without another, until done is closed. The file's
+// directory is watched rather than the file itself, so that the file being
+// replaced by a rename, as editors and atomic writers do, is noticed too.
+func watchFile(path string, delay time.Duration, reload func(), logger *slog.Logger, done <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		return err
+	}
+
+	path = filepath.Clean(path)
+	err = watcher.Add(filepath.Dir(path))
+
+	if err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		timer := time.NewTimer(delay)
+		timer.Stop()
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				if filepath.Clean(event.Name) == path && event.Has(fsnotify.Write|fsnotify.Create) {
+					timer.Reset(delay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+
+				logger.Error("Error watching feed file", "file", path, "error", err)
+			case <-timer.C:
+				reload()
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/jhammer/phishtankcheck/phishtank"
+)
+
+// webhook POSTs database changes to a URL.
+type webhook struct {
+	url    string
+	client *http.Client
+	logger *slog.Logger
+}
+
+// notify sends c in the background, logging rather than returning any error
+// so that a failing webhook can't hold up or fail a refresh.
+func (h *webhook) notify(c phishtank.Change) {
+	go func() {
+		err := h.post(c)
+
+		if err != nil {
+			h.logger.Error("Error sending webhook", "error", err)
+		}
+	}()
+}
+
+func (h *webhook) post(c phishtank.Change) error {
+	body, err := json.Marshal(c)
+
+	if err != nil {
+		return err
+	}
+
+	res, err := h.client.Post(h.url, "application/json", bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("bad status posting to %s: %v", h.url, res.StatusCode)
+	}
+
+	return nil
+}